@@ -13,14 +13,21 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/breaker"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/budget"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/cache"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/health"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/ratelimit"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/router"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// configPath is the configuration file, reloaded on SIGHUP and when it changes
+const configPath = "config/config.yaml"
+
 func main() {
 	log.Println("Starting RPC Load Balancer...")
 
@@ -30,7 +37,7 @@ func main() {
 	}
 
 	// Load configuration
-	cfg, err := config.Load("config/config.yaml")
+	cfg, err := config.Load(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -54,54 +61,89 @@ func main() {
 	// Initialize providers
 	providers := make([]provider.Provider, 0, len(cfg.Providers))
 	for _, p := range cfg.Providers {
-		var prov provider.Provider
-		switch p.Name {
-		case "helius":
-			prov = provider.NewHeliusProvider(p.URL, p.CostPerRequest)
-		case "alchemy":
-			prov = provider.NewAlchemyProvider(p.URL, p.CostPerRequest)
-		case "quicknode":
-			prov = provider.NewQuickNodeProvider(p.URL, p.CostPerRequest)
-		default:
-			log.Printf("Warning: unknown provider type '%s', using base provider", p.Name)
-			prov = provider.NewBaseProvider(p.Name, p.URL, p.CostPerRequest)
-		}
-		providers = append(providers, prov)
-
-		// Log masked URL for debugging
-		url := prov.URL()
-		maskedURL := url
-		if len(url) > 20 {
-			maskedURL = url[:20] + "..."
-		}
-		log.Printf("Initialized provider: %s (url: %s, cost: $%.6f/req)", prov.Name(), maskedURL, prov.CostPerRequest())
+		providers = append(providers, newProvider(p))
 	}
 
-	// Create provider pool
-	providerPool := pool.NewProviderPool(providers, redisClient)
+	// Create provider rate limiter, spend budgets and pool
+	limiter := ratelimit.NewLimiter(redisClient, cfg.Providers)
+	budgets := budget.NewTracker(redisClient, cfg.Budget, cfg.Providers)
+	providerPool, err := pool.NewProviderPool(providers, redisClient, cfg, limiter, budgets)
+	if err != nil {
+		log.Fatalf("Failed to create provider pool: %v", err)
+	}
 	log.Printf("Provider pool created with %d providers", providerPool.Size())
 
-	// Initialize RetryHandler with circuit breakers for each provider
-	providerNames := make([]string, 0, len(providers))
-	for _, p := range providers {
-		providerNames = append(providerNames, p.Name())
-	}
-	retryHandler := router.NewRetryHandler(providerPool, providerNames)
+	// Initialize RetryHandler with circuit breakers for each provider, shared across replicas
+	breakers := breaker.NewGroup(redisClient, cfg.Providers)
+	breakers.Start()
+	defer breakers.Stop()
+	retryHandler := router.NewRetryHandler(providerPool, breakers, cfg.Routing.Hedging, limiter)
 
 	// Start health monitor
-	healthMonitor := health.NewHealthMonitor(providers, redisClient, cfg.Health.CheckInterval)
+	healthMonitor := health.NewHealthMonitor(providers, redisClient, cfg.Health)
 	healthMonitor.Start()
 	defer healthMonitor.Stop()
 
 	// Initialize CacheHandler
-	cacheHandler := router.NewCacheHandler(redisClient, cfg.Caching)
+	cacheBackend, err := cache.NewBackend(cfg.Caching.Backend, redisClient, cfg.Caching.L1.MaxEntries, cfg.Caching.L1.MaxBytes)
+	if err != nil {
+		log.Fatalf("Failed to create cache backend: %v", err)
+	}
+	cacheHandler := router.NewCacheHandler(cacheBackend, cfg.Caching)
+	log.Printf("Cache backend: %s (l1: %v)", cfg.Caching.Backend, cfg.Caching.L1.Enabled)
+
+	// Initialize routing rules
+	ruleEngine := router.NewRuleEngine(cfg.Routing.Rules)
+	log.Printf("Loaded %d routing rules", len(cfg.Routing.Rules))
+
+	// Initialize transaction broadcaster
+	broadcaster := router.NewBroadcaster(retryHandler, redisClient, cfg.Routing.Broadcast)
+
+	// Initialize read-your-writes session tracking
+	sessionTracker := router.NewSessionTracker(redisClient, cfg.Routing.Consistency)
+
+	// Initialize coalescing of identical in-flight reads
+	coalescer := router.NewCoalescer(cacheHandler, redisClient, cfg.Caching.Coalescing)
+
+	// Initialize client API keys, rate limits and quotas
+	authenticator := router.NewAuthenticator(redisClient, cfg.Auth)
+	log.Printf("Client authentication: %v (%d configured keys)", cfg.Auth.Enabled, len(cfg.Auth.Keys))
 
 	// Create HTTP handler
-	handler := router.NewHandler(providerPool, retryHandler, cacheHandler)
+	handler := router.NewHandler(providerPool, retryHandler, cacheHandler, ruleEngine, broadcaster, sessionTracker, coalescer)
+
+	// Start WebSocket subscription proxy
+	subscriptionManager := router.NewSubscriptionManager(providerPool, retryHandler, ruleEngine, authenticator)
+	subscriptionManager.Start()
+	defer subscriptionManager.Stop()
+
+	// Reload the configuration on SIGHUP and when the file changes
+	reloader := &reloader{
+		path:         configPath,
+		cfg:          cfg,
+		providers:    providers,
+		limiter:      limiter,
+		budgets:      budgets,
+		pool:         providerPool,
+		breakers:     breakers,
+		retryHandler: retryHandler,
+		health:       healthMonitor,
+		cache:        cacheHandler,
+		rules:        ruleEngine,
+		broadcaster:  broadcaster,
+		sessions:     sessionTracker,
+		coalescer:    coalescer,
+		auth:         authenticator,
+	}
+	reloader.Start()
+	defer reloader.Stop()
 
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode) // Use gin.DebugMode for development
 	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
 	r.Use(gin.Recovery())
 	r.Use(customLogger())
 
@@ -109,7 +151,7 @@ func main() {
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Session-Id")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -118,9 +160,14 @@ func main() {
 	})
 
 	// Register routes
-	r.POST("/", handler.HandleRPC)                   // Main RPC endpoint
+	auth := authenticator.Middleware()
+	r.POST("/", auth, handler.HandleRPC)                    // Main RPC endpoint
+	r.POST("/rpc/:apiKey", auth, handler.HandleRPC)         // RPC endpoint with the API key in the path
+	r.GET("/ws", auth, subscriptionManager.HandleWebSocket) // WebSocket subscriptions
+	r.GET("/ws/:apiKey", auth, subscriptionManager.HandleWebSocket)
 	r.GET("/health", handler.HealthCheck)            // Health check endpoint
 	r.GET("/api/v1/status", handler.GetSystemStatus) // Dashboard status API
+	r.PUT("/api/v1/routing/strategy", auth, authenticator.AdminOnly(), handler.SetStrategy)
 	r.POST("/api/v1/chaos/trip", handler.TripProvider)
 	r.POST("/api/v1/chaos/reset", handler.ResetChaos)
 	r.POST("/api/v1/test-rpc", handler.TestRPC)      // Test RPC endpoint
@@ -146,6 +193,7 @@ func main() {
 
 	log.Println("✓ RPC Load Balancer is running!")
 	log.Printf("  - RPC Endpoint: http://localhost%s/", addr)
+	log.Printf("  - WebSocket: ws://localhost%s/ws", addr)
 	log.Printf("  - Health Check: http://localhost%s/health", addr)
 	log.Printf("  - Metrics: http://localhost%s/metrics", addr)
 
@@ -167,6 +215,31 @@ func main() {
 	log.Println("Server stopped")
 }
 
+// newProvider creates the client of a configured provider
+func newProvider(p config.ProviderConfig) provider.Provider {
+	var prov provider.Provider
+	switch p.Name {
+	case "helius":
+		prov = provider.NewHeliusProvider(p.URL, p.WSURL, p.CostPerRequest, p.MaxBatchSize)
+	case "alchemy":
+		prov = provider.NewAlchemyProvider(p.URL, p.WSURL, p.CostPerRequest, p.MaxBatchSize)
+	case "quicknode":
+		prov = provider.NewQuickNodeProvider(p.URL, p.WSURL, p.CostPerRequest, p.MaxBatchSize)
+	default:
+		log.Printf("Warning: unknown provider type '%s', using base provider", p.Name)
+		prov = provider.NewBaseProvider(p.Name, p.URL, p.WSURL, p.CostPerRequest, p.MaxBatchSize)
+	}
+
+	// Log masked URL for debugging
+	url := prov.URL()
+	maskedURL := url
+	if len(url) > 20 {
+		maskedURL = url[:20] + "..."
+	}
+	log.Printf("Initialized provider: %s (url: %s, cost: $%.6f/req)", prov.Name(), maskedURL, prov.CostPerRequest())
+	return prov
+}
+
 // customLogger is a custom Gin middleware for logging
 func customLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/breaker"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/budget"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/health"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/ratelimit"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/router"
+)
+
+const (
+	// reloadDebounce groups the burst of file events a single save produces
+	reloadDebounce = 500 * time.Millisecond
+
+	// drainTimeout bounds how long replaced providers wait for their in-flight requests
+	drainTimeout = 30 * time.Second
+)
+
+// reloader applies a changed configuration file to the running components, on SIGHUP
+// and whenever the file is written. A configuration that fails validation is rejected
+// and the current one stays in place.
+type reloader struct {
+	path string
+
+	mu        sync.Mutex
+	cfg       *config.Config
+	providers []provider.Provider
+
+	limiter      *ratelimit.Limiter
+	budgets      *budget.Tracker
+	pool         *pool.ProviderPool
+	breakers     *breaker.Group
+	retryHandler *router.RetryHandler
+	health       *health.HealthMonitor
+	cache        *router.CacheHandler
+	rules        *router.RuleEngine
+	broadcaster  *router.Broadcaster
+	sessions     *router.SessionTracker
+	coalescer    *router.Coalescer
+	auth         *router.Authenticator
+
+	hup     chan os.Signal
+	watcher *fsnotify.Watcher
+	cancel  context.CancelFunc
+}
+
+// Start listens for SIGHUP and watches the config file. Without a file watcher,
+// reloads are only triggered by SIGHUP.
+func (r *reloader) Start() {
+	r.path = filepath.Clean(r.path)
+	r.hup = make(chan os.Signal, 1)
+	signal.Notify(r.hup, syscall.SIGHUP)
+
+	// Watch the directory, so the file is still watched after editors replace it
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(r.path)); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("[RELOAD] Cannot watch %s, reloading on SIGHUP only: %v", r.path, err)
+	} else {
+		r.watcher = watcher
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.run(ctx, events, errs)
+	log.Printf("[RELOAD] Reloading %s on SIGHUP and file changes", r.path)
+}
+
+// Stop stops listening for reload triggers
+func (r *reloader) Stop() {
+	signal.Stop(r.hup)
+	r.cancel()
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+}
+
+func (r *reloader) run(ctx context.Context, events <-chan fsnotify.Event, errs <-chan error) {
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-r.hup:
+			r.reload("SIGHUP")
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if filepath.Clean(event.Name) == r.path && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				debounce = time.After(reloadDebounce)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Printf("[RELOAD] File watcher error: %v", err)
+		case <-debounce:
+			debounce = nil
+			r.reload("file change")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload loads and validates the config file and applies it, reporting the result
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.Printf("[RELOAD] Reloading configuration (%s)", trigger)
+	cfg, err := config.Load(r.path)
+	if err == nil {
+		err = r.apply(cfg)
+	}
+	if err != nil {
+		log.Printf("[RELOAD] Rejected new configuration, keeping the current one: %v", err)
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		metrics.ConfigLastReloadSuccessful.Set(0)
+		return
+	}
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	metrics.ConfigLastReloadSuccessful.Set(1)
+}
+
+// apply swaps the new configuration into the running components. Providers whose
+// endpoint and pricing did not change keep their instance (and connections); replaced
+// and removed instances are drained in the background. Callers must hold r.mu.
+//
+// Everything that can fail (config validation in Load, building the pool's strategies)
+// runs before the first swap, so a rejected configuration changes nothing. Each
+// component then swaps its own settings atomically, one after the other, so a request
+// may briefly see some components on the old settings and some on the new. That is
+// safe because every component serves providers it has no settings for with neutral
+// defaults (no breaker, no rate limit, no budget, not probed yet), and the order below
+// gives new providers their breaker and limits before the pool can route to them.
+func (r *reloader) apply(cfg *config.Config) error {
+	current := make(map[string]provider.Provider, len(r.providers))
+	for _, prov := range r.providers {
+		current[prov.Name()] = prov
+	}
+	previous := make(map[string]config.ProviderConfig, len(r.cfg.Providers))
+	for _, pc := range r.cfg.Providers {
+		previous[pc.Name] = pc
+	}
+
+	var added, replaced []string
+	providers := make([]provider.Provider, 0, len(cfg.Providers))
+	kept := make(map[provider.Provider]bool, len(cfg.Providers))
+	for _, pc := range cfg.Providers {
+		prov, ok := current[pc.Name]
+		switch {
+		case !ok:
+			added = append(added, pc.Name)
+			prov = newProvider(pc)
+		case !sameEndpoint(previous[pc.Name], pc):
+			replaced = append(replaced, pc.Name)
+			prov = newProvider(pc)
+		default:
+			kept[prov] = true
+		}
+		providers = append(providers, prov)
+	}
+
+	update, err := r.pool.PrepareUpdate(providers, cfg)
+	if err != nil {
+		return fmt.Errorf("provider pool: %w", err)
+	}
+
+	// Per-provider settings first, then the pool starts routing to the new providers
+	r.breakers.Reload(cfg.Providers)
+	r.limiter.Reload(cfg.Providers)
+	r.budgets.Reload(cfg.Budget, cfg.Providers)
+	r.pool.ApplyUpdate(update)
+	r.health.Reload(providers, cfg.Health)
+	r.retryHandler.Reload(cfg.Routing.Hedging)
+	r.cache.Reload(cfg.Caching)
+	r.coalescer.Reload(cfg.Caching.Coalescing)
+	r.rules.Reload(cfg.Routing.Rules)
+	r.broadcaster.Reload(cfg.Routing.Broadcast)
+	r.sessions.Reload(cfg.Routing.Consistency)
+	r.auth.Reload(cfg.Auth)
+
+	var removed []string
+	for _, prov := range r.providers {
+		if kept[prov] {
+			continue
+		}
+		if !containsName(cfg.Providers, prov.Name()) {
+			removed = append(removed, prov.Name())
+		}
+		go drain(prov)
+	}
+
+	warnRestart(r.cfg, cfg)
+	r.cfg, r.providers = cfg, providers
+	log.Printf("[RELOAD] Configuration reloaded: %d providers (added: %v, replaced: %v, removed: %v)",
+		len(providers), added, replaced, removed)
+	return nil
+}
+
+// sameEndpoint reports whether a provider instance created from prev can serve next
+func sameEndpoint(prev, next config.ProviderConfig) bool {
+	return prev.URL == next.URL && prev.WSURL == next.WSURL &&
+		prev.CostPerRequest == next.CostPerRequest && prev.MaxBatchSize == next.MaxBatchSize
+}
+
+func containsName(providers []config.ProviderConfig, name string) bool {
+	for _, pc := range providers {
+		if pc.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// drain waits for the requests still running on a replaced provider instance
+func drain(prov provider.Provider) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := prov.Drain(ctx); err != nil {
+		log.Printf("[RELOAD] Gave up draining old instance of %s: %v", prov.Name(), err)
+		return
+	}
+	log.Printf("[RELOAD] Drained old instance of %s", prov.Name())
+}
+
+// warnRestart logs the changed settings that are only read at startup
+func warnRestart(prev, next *config.Config) {
+	for _, name := range restartRequired(prev, next) {
+		log.Printf("[RELOAD] Changes to %s take effect after a restart", name)
+	}
+}
+
+// restartRequired returns the settings only read at startup that differ between prev and next
+func restartRequired(prev, next *config.Config) []string {
+	fixed := []struct {
+		name       string
+		prev, next interface{}
+	}{
+		{"server", prev.Server, next.Server},
+		{"redis", prev.Redis, next.Redis},
+		{"caching.backend", prev.Caching.Backend, next.Caching.Backend},
+		{"caching.l1", l1Fixed(prev.Caching.L1), l1Fixed(next.Caching.L1)},
+		{"caching.compression", prev.Caching.Compression, next.Caching.Compression},
+	}
+
+	var changed []string
+	for _, f := range fixed {
+		if !reflect.DeepEqual(f.prev, f.next) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
+
+// l1Fixed returns the L1 cache settings that are only read at startup; the TTL caps
+// are applied to every store and reload with the rest of the caching settings
+func l1Fixed(l1 config.L1CacheConfig) config.L1CacheConfig {
+	l1.MaxTTL, l1.MethodTTLs = 0, nil
+	return l1
+}
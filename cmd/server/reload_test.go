@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/breaker"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/budget"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/cache"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/health"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/ratelimit"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/router"
+)
+
+const baseConfig = `
+server:
+  port: 8080
+redis:
+  url: 127.0.0.1:1
+routing:
+  strategy: round-robin
+providers:
+  - name: helius
+    url: http://127.0.0.1:1/helius
+    cost_per_request: 0.0001
+  - name: alchemy
+    url: http://127.0.0.1:1/alchemy
+    cost_per_request: 0.0002
+`
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*config.Config)
+		want   []string
+	}{
+		{"nothing", func(*config.Config) {}, nil},
+		{"provider settings", func(c *config.Config) { c.Providers[0].Weight = 5 }, nil},
+		{"server port", func(c *config.Config) { c.Server.Port = 9090 }, []string{"server"}},
+		{"trusted proxies", func(c *config.Config) { c.Server.TrustedProxies = []string{"10.0.0.0/8"} }, []string{"server"}},
+		{"redis", func(c *config.Config) { c.Redis.DB = 2 }, []string{"redis"}},
+		{"cache backend", func(c *config.Config) { c.Caching.Backend = "tiered" }, []string{"caching.backend"}},
+		{"l1 size", func(c *config.Config) { c.Caching.L1.MaxEntries = 10 }, []string{"caching.l1"}},
+		{"l1 ttl caps reload", func(c *config.Config) {
+			c.Caching.L1.MaxTTL = time.Second
+			c.Caching.L1.MethodTTLs = map[string]time.Duration{"getSlot": time.Second}
+		}, nil},
+		{"compression", func(c *config.Config) { c.Caching.Compression.Enabled = true }, []string{"caching.compression"}},
+		{"several", func(c *config.Config) {
+			c.Server.Port = 9090
+			c.Caching.Compression.MinSize = 1024
+		}, []string{"server", "caching.compression"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := loadConfig(t, baseConfig)
+			next := loadConfig(t, baseConfig)
+			tt.change(next)
+
+			if got := restartRequired(prev, next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restartRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloaderApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		want     []string // provider names after the reload
+		kept     []string // providers that keep their instance
+		rejected bool
+	}{
+		{
+			name:   "unchanged",
+			config: baseConfig,
+			want:   []string{"helius", "alchemy"},
+			kept:   []string{"helius", "alchemy"},
+		},
+		{
+			name: "routing settings keep instances",
+			config: `
+server: {port: 8080}
+redis: {url: 127.0.0.1:1}
+routing: {strategy: weighted-round-robin}
+providers:
+  - {name: helius, url: http://127.0.0.1:1/helius, cost_per_request: 0.0001, weight: 3}
+  - {name: alchemy, url: http://127.0.0.1:1/alchemy, cost_per_request: 0.0002, priority: 2}
+`,
+			want: []string{"helius", "alchemy"},
+			kept: []string{"helius", "alchemy"},
+		},
+		{
+			name: "changed endpoint replaces the instance",
+			config: `
+server: {port: 8080}
+redis: {url: 127.0.0.1:1}
+routing: {strategy: round-robin}
+providers:
+  - {name: helius, url: http://127.0.0.1:2/helius, cost_per_request: 0.0001}
+  - {name: alchemy, url: http://127.0.0.1:1/alchemy, cost_per_request: 0.0002}
+`,
+			want: []string{"helius", "alchemy"},
+			kept: []string{"alchemy"},
+		},
+		{
+			name: "changed price replaces the instance",
+			config: `
+server: {port: 8080}
+redis: {url: 127.0.0.1:1}
+routing: {strategy: round-robin}
+providers:
+  - {name: helius, url: http://127.0.0.1:1/helius, cost_per_request: 0.0001}
+  - {name: alchemy, url: http://127.0.0.1:1/alchemy, cost_per_request: 0.0003}
+`,
+			want: []string{"helius", "alchemy"},
+			kept: []string{"helius"},
+		},
+		{
+			name: "added and removed",
+			config: `
+server: {port: 8080}
+redis: {url: 127.0.0.1:1}
+routing: {strategy: round-robin}
+providers:
+  - {name: alchemy, url: http://127.0.0.1:1/alchemy, cost_per_request: 0.0002}
+  - {name: quicknode, url: http://127.0.0.1:1/quicknode, cost_per_request: 0.0001}
+`,
+			want: []string{"alchemy", "quicknode"},
+			kept: []string{"alchemy"},
+		},
+		{
+			name:     "invalid YAML",
+			config:   "providers: [",
+			want:     []string{"helius", "alchemy"},
+			kept:     []string{"helius", "alchemy"},
+			rejected: true,
+		},
+		{
+			name: "failed validation",
+			config: `
+server: {port: 8080}
+redis: {url: 127.0.0.1:1}
+providers:
+  - {name: helius, url: ftp://127.0.0.1:1/helius}
+`,
+			want:     []string{"helius", "alchemy"},
+			kept:     []string{"helius", "alchemy"},
+			rejected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReloader(t)
+			before := make(map[string]provider.Provider)
+			for _, prov := range r.providers {
+				before[prov.Name()] = prov
+			}
+			prevCfg := r.cfg
+
+			if err := os.WriteFile(r.path, []byte(tt.config), 0o600); err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+			r.reload("test")
+
+			if tt.rejected != (r.cfg == prevCfg) {
+				t.Fatalf("config replaced = %v, want %v", r.cfg != prevCfg, !tt.rejected)
+			}
+			if got := names(r.providers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reloader providers = %v, want %v", got, tt.want)
+			}
+			if got := names(r.pool.GetAll()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pool providers = %v, want %v", got, tt.want)
+			}
+			for _, prov := range r.providers {
+				kept := before[prov.Name()] == prov
+				if want := slices.Contains(tt.kept, prov.Name()); kept != want {
+					t.Errorf("%s kept its instance = %v, want %v", prov.Name(), kept, want)
+				}
+				if r.breakers.Get(prov.Name()) == nil {
+					t.Errorf("%s has no circuit breaker", prov.Name())
+				}
+			}
+			for name := range before {
+				if !slices.Contains(tt.want, name) && r.breakers.Get(name) != nil {
+					t.Errorf("removed provider %s still has a circuit breaker", name)
+				}
+			}
+		})
+	}
+}
+
+// loadConfig loads and validates a config from YAML
+func loadConfig(t *testing.T, data string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return cfg
+}
+
+// newTestReloader wires the reloadable components like main does, from baseConfig,
+// without a Redis client
+func newTestReloader(t *testing.T) *reloader {
+	t.Helper()
+	cfg := loadConfig(t, baseConfig)
+	var rdb *redis.Client
+
+	providers := make([]provider.Provider, 0, len(cfg.Providers))
+	for _, pc := range cfg.Providers {
+		providers = append(providers, newProvider(pc))
+	}
+	limiter := ratelimit.NewLimiter(rdb, cfg.Providers)
+	budgets := budget.NewTracker(rdb, cfg.Budget, cfg.Providers)
+	providerPool, err := pool.NewProviderPool(providers, rdb, cfg, limiter, budgets)
+	if err != nil {
+		t.Fatalf("NewProviderPool: %v", err)
+	}
+	breakers := breaker.NewGroup(nil, cfg.Providers)
+	retryHandler := router.NewRetryHandler(providerPool, breakers, cfg.Routing.Hedging, limiter)
+	cacheBackend, err := cache.NewBackend(cfg.Caching.Backend, rdb, cfg.Caching.L1.MaxEntries, cfg.Caching.L1.MaxBytes)
+	if err != nil {
+		t.Fatalf("NewBackend: %v", err)
+	}
+	cacheHandler := router.NewCacheHandler(cacheBackend, cfg.Caching)
+
+	return &reloader{
+		path:         filepath.Join(t.TempDir(), "config.yaml"),
+		cfg:          cfg,
+		providers:    providers,
+		limiter:      limiter,
+		budgets:      budgets,
+		pool:         providerPool,
+		breakers:     breakers,
+		retryHandler: retryHandler,
+		health:       health.NewHealthMonitor(providers, rdb, cfg.Health),
+		cache:        cacheHandler,
+		rules:        router.NewRuleEngine(cfg.Routing.Rules),
+		broadcaster:  router.NewBroadcaster(retryHandler, rdb, cfg.Routing.Broadcast),
+		sessions:     router.NewSessionTracker(rdb, cfg.Routing.Consistency),
+		coalescer:    router.NewCoalescer(cacheHandler, rdb, cfg.Caching.Coalescing),
+		auth:         router.NewAuthenticator(rdb, cfg.Auth),
+	}
+}
+
+func names(providers []provider.Provider) []string {
+	var out []string
+	for _, prov := range providers {
+		out = append(out, prov.Name())
+	}
+	return out
+}
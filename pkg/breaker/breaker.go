@@ -0,0 +1,279 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// probeRecheck is how often an open breaker past its timeout asks the health probe again
+const probeRecheck = time.Second
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+var (
+	// ErrOpenState is returned while the breaker is open
+	ErrOpenState = errors.New("circuit breaker is open")
+	// ErrTooManyRequests is returned while half-open once the trial requests are used up
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// counts are the attempts of the current generation
+type counts struct {
+	requests             uint32
+	failures             uint32
+	consecutiveFailures  uint32
+	consecutiveSuccesses uint32
+}
+
+// Breaker is the circuit breaker of one provider
+type Breaker struct {
+	name   string
+	config config.CircuitBreakerConfig
+	group  *Group
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	counts     counts
+	expiry     time.Time // closed: end of the counting interval; open: earliest half-open time
+	openedAt   time.Time
+
+	probing atomic.Bool // a health probe lookup is in flight
+}
+
+func newBreaker(name string, cfg config.CircuitBreakerConfig, group *Group) *Breaker {
+	b := &Breaker{name: name, config: cfg, group: group}
+	b.expiry = time.Now().Add(cfg.Interval)
+	metrics.CircuitBreakerState.WithLabelValues(name).Set(float64(StateClosed))
+	return b
+}
+
+// reconfigure applies new settings. The current counting interval or open timeout
+// runs to its end with the old settings.
+func (b *Breaker) reconfigure(cfg config.CircuitBreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.config = cfg
+}
+
+// outcome is how an attempt counts towards the breaker
+type outcome int
+
+const (
+	success outcome = iota
+	failure
+	neutral // counts neither way
+)
+
+// Execute runs fn if the breaker allows a request and counts its outcome
+func (b *Breaker) Execute(fn func() (interface{}, error)) (interface{}, error) {
+	generation, err := b.before()
+	if err != nil {
+		return nil, err
+	}
+	result, err := fn()
+	b.after(generation, outcomeOf(err))
+	return result, err
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current(time.Now())
+}
+
+// outcomeOf classifies an attempt. A request cancelled by the caller (e.g. the losing side
+// of a hedge) says nothing about the provider's health and is neutral; one rejected for
+// exceeding the plan's rate limit was answered by a working provider and counts as a success.
+func outcomeOf(err error) outcome {
+	var rateLimited *provider.RateLimitError
+	switch {
+	case err == nil, errors.As(err, &rateLimited):
+		return success
+	case errors.Is(err, context.Canceled):
+		return neutral
+	}
+	return failure
+}
+
+func (b *Breaker) before() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.current(time.Now()) {
+	case StateOpen:
+		return 0, ErrOpenState
+	case StateHalfOpen:
+		if b.counts.requests >= b.config.MaxRequests {
+			return 0, ErrTooManyRequests
+		}
+	}
+	b.counts.requests++
+	return b.generation, nil
+}
+
+func (b *Breaker) after(generation uint64, result outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	state := b.current(now)
+	if generation != b.generation {
+		// The breaker changed state since the request started
+		return
+	}
+
+	switch result {
+	case neutral:
+		// Give back the request slot, so it neither dilutes the failure ratio nor uses
+		// up a half-open trial
+		b.counts.requests--
+		return
+	case success:
+		b.counts.consecutiveSuccesses++
+		b.counts.consecutiveFailures = 0
+		if state == StateHalfOpen && b.counts.consecutiveSuccesses >= b.config.MaxRequests {
+			b.setState(StateClosed, now, false)
+		}
+		return
+	}
+
+	b.counts.failures++
+	b.counts.consecutiveFailures++
+	b.counts.consecutiveSuccesses = 0
+	if state == StateHalfOpen || b.readyToTrip() {
+		b.setState(StateOpen, now, false)
+	}
+}
+
+// readyToTrip reports whether the closed breaker should open. Callers must hold b.mu.
+func (b *Breaker) readyToTrip() bool {
+	c := b.counts
+	if c.consecutiveFailures >= b.config.ConsecutiveFailures {
+		return true
+	}
+	return b.config.FailureRatio > 0 && c.requests >= b.config.MinRequests &&
+		float64(c.failures)/float64(c.requests) >= b.config.FailureRatio
+}
+
+// current advances time-based transitions and returns the state. An open breaker only
+// becomes half-open once the health probe found the provider healthy after it opened;
+// that is looked up in the background, so no Redis round trip runs under b.mu.
+// Callers must hold b.mu.
+func (b *Breaker) current(now time.Time) State {
+	switch b.state {
+	case StateClosed:
+		if now.After(b.expiry) {
+			b.generation++
+			b.counts = counts{}
+			b.expiry = now.Add(b.config.Interval)
+		}
+	case StateOpen:
+		if now.After(b.expiry) {
+			if !b.group.probing() {
+				b.setState(StateHalfOpen, now, false)
+			} else {
+				b.expiry = now.Add(probeRecheck)
+				b.checkProbe()
+			}
+		}
+	}
+	return b.state
+}
+
+// checkProbe asks the health probe whether the provider recovered since the breaker
+// opened and moves the breaker to half-open if it did. At most one lookup per breaker
+// is in flight. Callers must hold b.mu.
+func (b *Breaker) checkProbe() {
+	if !b.probing.CompareAndSwap(false, true) {
+		return
+	}
+
+	openedAt := b.openedAt
+	go func() {
+		defer b.probing.Store(false)
+		if !b.group.probe(b.name, openedAt) {
+			return
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// Skip if the breaker was closed or reopened in the meantime
+		if b.state == StateOpen && b.openedAt.Equal(openedAt) {
+			b.setState(StateHalfOpen, time.Now(), false)
+		}
+	}()
+}
+
+// setState moves the breaker to a new state and starts a new generation. Opening and
+// closing are shared with the other replicas unless they came from one.
+// Callers must hold b.mu.
+func (b *Breaker) setState(to State, now time.Time, remote bool) {
+	from := b.state
+	if from == to {
+		return
+	}
+
+	b.state = to
+	b.generation++
+	b.counts = counts{}
+	switch to {
+	case StateClosed:
+		b.expiry = now.Add(b.config.Interval)
+	case StateOpen:
+		b.openedAt = now
+		b.expiry = now.Add(b.config.Timeout)
+	}
+
+	metrics.CircuitBreakerState.WithLabelValues(b.name).Set(float64(to))
+	if remote {
+		log.Printf("[CIRCUIT-BREAKER] Provider %s state changed from %s to %s (by another replica)", b.name, from, to)
+		return
+	}
+	log.Printf("[CIRCUIT-BREAKER] Provider %s state changed from %s to %s", b.name, from, to)
+	if to != StateHalfOpen {
+		b.group.publish(b.name, to, b.expiry)
+	}
+}
+
+// apply takes over a transition made by another replica. An open breaker stays open
+// until the time the other replica gave it.
+func (b *Breaker) apply(to State, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if to == StateOpen && b.state == StateOpen {
+		return
+	}
+	b.setState(to, now, true)
+	if to == StateOpen && until.After(now) {
+		b.expiry = until
+	}
+}
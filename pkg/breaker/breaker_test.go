@@ -0,0 +1,267 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+const testTimeout = 20 * time.Millisecond
+
+var (
+	errUpstream  = errors.New("upstream failed")
+	errCancelled = fmt.Errorf("request aborted: %w", context.Canceled)
+	errThrottled = &provider.RateLimitError{Provider: "test"}
+)
+
+func testConfig() config.CircuitBreakerConfig {
+	return config.CircuitBreakerConfig{
+		MaxRequests:         2,
+		Timeout:             testTimeout,
+		Interval:            time.Hour,
+		ConsecutiveFailures: 3,
+		MinRequests:         4,
+	}
+}
+
+// step is one attempt through the breaker, optionally after waiting
+type step struct {
+	wait    time.Duration
+	err     error // outcome of the attempt
+	wantErr error // rejection by the breaker, instead of running the attempt
+	want    State // state after the step
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*config.CircuitBreakerConfig)
+		steps  []step
+	}{
+		{
+			name: "consecutive failures open",
+			steps: []step{
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateOpen},
+				{wantErr: ErrOpenState, want: StateOpen},
+			},
+		},
+		{
+			name: "success resets the streak",
+			steps: []step{
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateClosed},
+				{want: StateClosed},
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateOpen},
+			},
+		},
+		{
+			name:   "failure ratio opens",
+			config: func(c *config.CircuitBreakerConfig) { c.FailureRatio = 0.5; c.ConsecutiveFailures = 10 },
+			steps: []step{
+				{err: errUpstream, want: StateClosed},
+				{want: StateClosed},
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateOpen},
+			},
+		},
+		{
+			name: "half-open closes after the trial requests",
+			steps: []step{
+				{err: errUpstream}, {err: errUpstream}, {err: errUpstream, want: StateOpen},
+				{wait: 2 * testTimeout, want: StateHalfOpen},
+				{want: StateClosed},
+			},
+		},
+		{
+			name: "half-open reopens on failure",
+			steps: []step{
+				{err: errUpstream}, {err: errUpstream}, {err: errUpstream, want: StateOpen},
+				{wait: 2 * testTimeout, want: StateHalfOpen},
+				{err: errUpstream, want: StateOpen},
+				{wantErr: ErrOpenState, want: StateOpen},
+			},
+		},
+		{
+			name: "cancelled requests do not trip",
+			steps: []step{
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateClosed},
+				{err: errCancelled, want: StateClosed},
+				{err: errCancelled, want: StateClosed},
+				{err: errUpstream, want: StateOpen},
+			},
+		},
+		{
+			name:   "cancelled requests do not dilute the failure ratio",
+			config: func(c *config.CircuitBreakerConfig) { c.FailureRatio = 0.5; c.ConsecutiveFailures = 10 },
+			steps: []step{
+				{err: errUpstream, want: StateClosed},
+				{err: errCancelled, want: StateClosed},
+				{err: errCancelled, want: StateClosed},
+				{err: errCancelled, want: StateClosed},
+				{want: StateClosed},
+				{err: errUpstream, want: StateClosed},
+				{err: errUpstream, want: StateOpen},
+			},
+		},
+		{
+			name: "cancelled trial requests are given back",
+			steps: []step{
+				{err: errUpstream}, {err: errUpstream}, {err: errUpstream, want: StateOpen},
+				{wait: 2 * testTimeout, err: errCancelled, want: StateHalfOpen},
+				{err: errCancelled, want: StateHalfOpen},
+				{want: StateHalfOpen},
+				{want: StateClosed},
+			},
+		},
+		{
+			name: "rate limiting counts as success",
+			steps: []step{
+				{err: errUpstream}, {err: errUpstream}, {err: errUpstream, want: StateOpen},
+				{wait: 2 * testTimeout, err: errThrottled, want: StateHalfOpen},
+				{err: errThrottled, want: StateClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			if tt.config != nil {
+				tt.config(&cfg)
+			}
+			g := NewGroup(nil, []config.ProviderConfig{{Name: "test", CircuitBreaker: cfg}})
+			b := g.Get("test")
+
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+				ran := false
+				_, err := b.Execute(func() (interface{}, error) {
+					ran = true
+					return nil, s.err
+				})
+
+				if s.wantErr != nil {
+					if ran || !errors.Is(err, s.wantErr) {
+						t.Fatalf("step %d: err = %v (ran: %v), want rejection %v", i, err, ran, s.wantErr)
+					}
+				} else if !ran {
+					t.Fatalf("step %d: rejected with %v, want the attempt to run", i, err)
+				}
+				if got := b.State(); got != s.want {
+					t.Fatalf("step %d: state = %s, want %s", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpenLimit(t *testing.T) {
+	g := NewGroup(nil, []config.ProviderConfig{{Name: "test", CircuitBreaker: testConfig()}})
+	b := g.Get("test")
+	for i := 0; i < 3; i++ {
+		b.Execute(func() (interface{}, error) { return nil, errUpstream })
+	}
+	time.Sleep(2 * testTimeout)
+
+	// Trial requests in flight use up the half-open budget
+	var gens []uint64
+	for i := 0; i < 2; i++ {
+		gen, err := b.before()
+		if err != nil {
+			t.Fatalf("trial %d: %v", i, err)
+		}
+		gens = append(gens, gen)
+	}
+	if _, err := b.before(); !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("third trial: err = %v, want %v", err, ErrTooManyRequests)
+	}
+
+	// A cancelled trial frees its slot
+	b.after(gens[0], neutral)
+	gen, err := b.before()
+	if err != nil {
+		t.Fatalf("trial after cancellation: %v", err)
+	}
+	b.after(gens[1], success)
+	b.after(gen, success)
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("state = %s, want %s", got, StateClosed)
+	}
+}
+
+func TestBreakerProbeOutsideLock(t *testing.T) {
+	g := NewGroup(nil, []config.ProviderConfig{{Name: "test", CircuitBreaker: testConfig()}})
+	lookups := make(chan struct{}, 10)
+	healthy := make(chan bool)
+	g.probe = func(name string, since time.Time) bool {
+		lookups <- struct{}{}
+		return <-healthy
+	}
+	b := g.Get("test")
+
+	for i := 0; i < 3; i++ {
+		b.Execute(func() (interface{}, error) { return nil, errUpstream })
+	}
+	time.Sleep(2 * testTimeout)
+
+	// The breaker answers while the lookup is blocked, and starts only one lookup
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("state = %s, want %s while the probe is looked up", got, StateOpen)
+	}
+	<-lookups
+	time.Sleep(2 * probeRecheck)
+	if _, err := b.Execute(func() (interface{}, error) { return nil, nil }); !errors.Is(err, ErrOpenState) {
+		t.Fatalf("err = %v, want %v while the probe is looked up", err, ErrOpenState)
+	}
+	if len(lookups) != 0 {
+		t.Fatalf("%d more lookups started while one was in flight", len(lookups))
+	}
+
+	// An unhealthy probe keeps it open; the next look after probeRecheck asks again
+	healthy <- false
+	time.Sleep(2 * probeRecheck)
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("state = %s, want %s after an unhealthy probe", got, StateOpen)
+	}
+	<-lookups
+	healthy <- true
+
+	deadline := time.Now().Add(time.Second)
+	for b.State() != StateHalfOpen {
+		if time.Now().After(deadline) {
+			t.Fatalf("breaker did not go half-open after a healthy probe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOutcomeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want outcome
+	}{
+		{nil, success},
+		{errThrottled, success},
+		{fmt.Errorf("attempt: %w", errThrottled), success},
+		{context.Canceled, neutral},
+		{errCancelled, neutral},
+		{context.DeadlineExceeded, failure},
+		{errUpstream, failure},
+	}
+
+	for _, tt := range tests {
+		if got := outcomeOf(tt.err); got != tt.want {
+			t.Errorf("outcomeOf(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package breaker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/health"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+)
+
+const (
+	// channel carries breaker transitions between replicas
+	channel = "breaker:transitions"
+
+	// openKeyPrefix marks a breaker open until the key expires, for replicas that start later
+	openKeyPrefix = "breaker:open:"
+
+	redisTimeout = 2 * time.Second
+)
+
+// transition is an open or close published to the other replicas
+type transition struct {
+	Replica  string    `json:"replica"`
+	Provider string    `json:"provider"`
+	State    string    `json:"state"`
+	Until    time.Time `json:"until,omitempty"` // end of the open timeout
+}
+
+// Group holds the circuit breakers of all providers and shares their openings and
+// closings with other replicas through Redis pub/sub, so a provider found dead by one
+// replica is avoided by all of them. If Redis is unavailable breakers work locally.
+type Group struct {
+	redis    *redis.Client
+	replica  string
+	mu       sync.RWMutex
+	breakers map[string]*Breaker
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// probe looks up whether a provider was probed healthy since a time; nil without Redis
+	probe func(name string, since time.Time) bool
+}
+
+// NewGroup creates a breaker for every provider from its circuit breaker settings
+func NewGroup(redisClient *redis.Client, providers []config.ProviderConfig) *Group {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &Group{
+		redis:    redisClient,
+		replica:  hex.EncodeToString(id),
+		breakers: make(map[string]*Breaker, len(providers)),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	if redisClient != nil {
+		g.probe = g.probedHealthy
+	}
+	for _, p := range providers {
+		g.breakers[p.Name] = newBreaker(p.Name, p.CircuitBreaker, g)
+	}
+	return g
+}
+
+// Start restores breakers other replicas have opened and listens for their transitions
+func (g *Group) Start() {
+	if g.redis == nil {
+		return
+	}
+
+	g.mu.RLock()
+	g.restore(g.breakers)
+	g.mu.RUnlock()
+
+	pubsub := g.redis.Subscribe(g.ctx, channel)
+	go func() {
+		defer pubsub.Close()
+		for {
+			select {
+			case msg, ok := <-pubsub.Channel():
+				if !ok {
+					return
+				}
+				g.receive(msg.Payload)
+			case <-g.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// restore opens the given breakers that another replica has opened
+func (g *Group) restore(breakers map[string]*Breaker) {
+	if g.redis == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(g.ctx, redisTimeout)
+	defer cancel()
+	for name, b := range breakers {
+		ttl, err := g.redis.PTTL(ctx, openKeyPrefix+name).Result()
+		if err != nil {
+			log.Printf("[CIRCUIT-BREAKER] Failed to read shared breaker states: %v", err)
+			return
+		}
+		if ttl > 0 {
+			b.apply(StateOpen, time.Now().Add(ttl))
+		}
+	}
+}
+
+// Reload applies new circuit breaker settings. Breakers of providers that are still
+// configured keep their state; new providers get a closed breaker (or the one other
+// replicas share), and breakers of removed providers are dropped.
+func (g *Group) Reload(providers []config.ProviderConfig) {
+	added := make(map[string]*Breaker)
+
+	g.mu.Lock()
+	breakers := make(map[string]*Breaker, len(providers))
+	for _, p := range providers {
+		if b, ok := g.breakers[p.Name]; ok {
+			b.reconfigure(p.CircuitBreaker)
+			breakers[p.Name] = b
+			continue
+		}
+		breakers[p.Name] = newBreaker(p.Name, p.CircuitBreaker, g)
+		added[p.Name] = breakers[p.Name]
+	}
+	for name := range g.breakers {
+		if _, ok := breakers[name]; !ok {
+			metrics.CircuitBreakerState.DeleteLabelValues(name)
+		}
+	}
+	g.breakers = breakers
+	g.mu.Unlock()
+
+	g.restore(added)
+}
+
+// Stop stops listening for transitions of other replicas
+func (g *Group) Stop() {
+	g.cancel()
+}
+
+// Get returns the breaker of a provider, or nil if it has none
+func (g *Group) Get(name string) *Breaker {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.breakers[name]
+}
+
+// States returns the state of every breaker
+func (g *Group) States() map[string]State {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	states := make(map[string]State, len(g.breakers))
+	for name, b := range g.breakers {
+		states[name] = b.State()
+	}
+	return states
+}
+
+// receive applies a transition published by another replica
+func (g *Group) receive(payload string) {
+	var t transition
+	if err := json.Unmarshal([]byte(payload), &t); err != nil {
+		log.Printf("[CIRCUIT-BREAKER] Ignoring malformed transition: %v", err)
+		return
+	}
+	b := g.Get(t.Provider)
+	if b == nil || t.Replica == g.replica {
+		return
+	}
+	switch t.State {
+	case StateOpen.String():
+		b.apply(StateOpen, t.Until)
+	case StateClosed.String():
+		b.apply(StateClosed, time.Time{})
+	}
+}
+
+// publish shares an open or close with the other replicas in the background
+func (g *Group) publish(name string, to State, until time.Time) {
+	if g.redis == nil {
+		return
+	}
+
+	t := transition{Replica: g.replica, Provider: name, State: to.String()}
+	if to == StateOpen {
+		t.Until = until
+	}
+	payload, _ := json.Marshal(t)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(g.ctx, redisTimeout)
+		defer cancel()
+
+		pipe := g.redis.TxPipeline()
+		if to == StateOpen {
+			pipe.Set(ctx, openKeyPrefix+name, g.replica, time.Until(until))
+		} else {
+			pipe.Del(ctx, openKeyPrefix+name)
+		}
+		pipe.Publish(ctx, channel, payload)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("[CIRCUIT-BREAKER] Failed to share %s transition of %s: %v", to, name, err)
+		}
+	}()
+}
+
+// probing reports whether open breakers wait for a health probe before going half-open
+func (g *Group) probing() bool {
+	return g.probe != nil
+}
+
+// probedHealthy reports whether a health probe since the given time found the provider
+// healthy. Without a probe result (no monitor data, Redis down) the provider is given
+// the benefit of the doubt.
+func (g *Group) probedHealthy(name string, since time.Time) bool {
+	if g.redis == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(g.ctx, redisTimeout)
+	defer cancel()
+
+	status, err := health.GetProviderStatus(ctx, g.redis, name)
+	if err != nil || status == nil {
+		return true
+	}
+	return status.Healthy && status.LastCheck.After(since)
+}
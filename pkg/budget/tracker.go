@@ -0,0 +1,365 @@
+package budget
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+const (
+	keyPrefix = "spend:"
+
+	// globalScope is the scope of the budget shared by all providers
+	globalScope = ""
+
+	// refreshInterval is how often spend written by other replicas is read back from Redis
+	refreshInterval = time.Second
+	refreshTimeout  = 2 * time.Second
+)
+
+// State is where a provider stands against its budget
+type State int
+
+const (
+	OK        State = iota
+	Soft            // past the soft threshold: cheaper providers are preferred
+	Exhausted       // at the limit: used only as a last resort
+)
+
+func (s State) String() string {
+	switch s {
+	case Soft:
+		return "soft"
+	case Exhausted:
+		return "exhausted"
+	}
+	return "ok"
+}
+
+// Spend is the spend in USD of the current hour, day and month (UTC)
+type Spend struct {
+	Hourly  float64 `json:"hourly"`
+	Daily   float64 `json:"daily"`
+	Monthly float64 `json:"monthly"`
+}
+
+// values returns the hourly, daily and monthly spend, in the order of periods
+func (s *Spend) values() []*float64 {
+	return []*float64{&s.Hourly, &s.Daily, &s.Monthly}
+}
+
+// Status describes a budget for the status API
+type Status struct {
+	State  string              `json:"state"`
+	Since  time.Time           `json:"since"`
+	Spend  Spend               `json:"spend_usd"`
+	Limits config.BudgetConfig `json:"limits"`
+}
+
+// scope is the tracked spend of one budget
+type scope struct {
+	limits config.BudgetConfig
+	spend  Spend
+	keys   [3]string // counters the hourly, daily and monthly spend were read from
+	state  State
+	since  time.Time
+}
+
+// rollOver zeroes the spend of periods that ended since the scope was last read or
+// recorded, so it does not wait on Redis to leave an exhausted hour or day.
+// It reports whether any period rolled over.
+func (s *scope) rollOver(id string, now time.Time) bool {
+	rolled := false
+	spend := s.spend.values()
+	for i, period := range periods(id, now) {
+		if s.keys[i] != period.key {
+			*spend[i] = 0
+			s.keys[i] = period.key
+			rolled = true
+		}
+	}
+	return rolled
+}
+
+// Tracker prices requests and keeps spend per provider and globally in Redis counters
+// per hour, day and month, so spend survives restarts and is shared by replicas. Only
+// scopes with a limit are tracked.
+type Tracker struct {
+	redis     *redis.Client
+	mu        sync.Mutex
+	costs     map[string]map[string]float64 // provider -> method cost table
+	scopes    map[string]*scope
+	refreshed time.Time
+
+	refreshing atomic.Bool
+}
+
+// NewTracker creates a tracker for the global budget and the providers that set one
+func NewTracker(redisClient *redis.Client, global config.BudgetConfig, providers []config.ProviderConfig) *Tracker {
+	t := &Tracker{
+		redis:  redisClient,
+		scopes: make(map[string]*scope),
+	}
+	t.Reload(global, providers)
+	return t
+}
+
+// Reload replaces the method cost tables and budget limits. Spend counters live in
+// Redis, so scopes that are still limited keep their spend and state.
+func (t *Tracker) Reload(global config.BudgetConfig, providers []config.ProviderConfig) {
+	limits := make(map[string]config.BudgetConfig)
+	if global.Limited() {
+		limits[globalScope] = global
+	}
+	costs := make(map[string]map[string]float64)
+	for _, p := range providers {
+		if len(p.MethodCosts) > 0 {
+			costs[p.Name] = p.MethodCosts
+		}
+		if p.Budget.Limited() {
+			limits[p.Name] = p.Budget
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.costs = costs
+	now := time.Now()
+	scopes := make(map[string]*scope, len(limits))
+	for id, l := range limits {
+		s, ok := t.scopes[id]
+		if !ok {
+			s = &scope{since: now}
+		}
+		s.limits = l
+		scopes[id] = s
+	}
+	t.scopes = scopes
+	t.refreshed = time.Time{}
+}
+
+// Cost returns the price in USD of one request for method sent to prov
+func (t *Tracker) Cost(prov provider.Provider, method string) float64 {
+	if t != nil {
+		t.mu.Lock()
+		costs := t.costs[prov.Name()]
+		t.mu.Unlock()
+		if cost, ok := config.MethodValue(costs, method); ok {
+			return cost
+		}
+	}
+	return prov.CostPerRequest()
+}
+
+// Enabled reports whether any budget is configured
+func (t *Tracker) Enabled() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.scopes) > 0
+}
+
+// Record adds spend on a provider to its budget and the global budget
+func (t *Tracker) Record(ctx context.Context, name string, usd float64) {
+	if !t.Enabled() || usd <= 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	// The spend is already incurred, so a client disconnect must not drop it
+	ctx = context.WithoutCancel(ctx)
+	pipe := t.redis.Pipeline()
+
+	t.mu.Lock()
+	for _, id := range []string{name, globalScope} {
+		s, ok := t.scopes[id]
+		if !ok {
+			continue
+		}
+		for _, period := range periods(id, now) {
+			pipe.IncrByFloat(ctx, period.key, usd)
+			pipe.ExpireAt(ctx, period.key, period.end.Add(time.Hour))
+		}
+		s.rollOver(id, now)
+		s.spend.Hourly += usd
+		s.spend.Daily += usd
+		s.spend.Monthly += usd
+		t.update(id, s)
+	}
+	t.mu.Unlock()
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("[BUDGET] Failed to record spend for %s: %v", name, err)
+	}
+}
+
+// State returns the budget state of a provider: the worse of its own and the global one
+func (t *Tracker) State(ctx context.Context, name string) State {
+	if !t.Enabled() {
+		return OK
+	}
+
+	t.maybeRefresh()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := OK
+	for _, id := range []string{name, globalScope} {
+		if s, ok := t.scopes[id]; ok && s.state > state {
+			state = s.state
+		}
+	}
+	return state
+}
+
+// Status returns the budget of a provider, or the global budget for "", if it has one
+func (t *Tracker) Status(ctx context.Context, name string) *Status {
+	if !t.Enabled() {
+		return nil
+	}
+
+	t.maybeRefresh()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.scopes[name]
+	if !ok {
+		return nil
+	}
+	return &Status{
+		State:  s.state.String(),
+		Since:  s.since,
+		Spend:  s.spend,
+		Limits: s.limits,
+	}
+}
+
+// maybeRefresh starts a background refresh once per refreshInterval, so requests only
+// read the cached spend and never wait on Redis
+func (t *Tracker) maybeRefresh() {
+	t.mu.Lock()
+	due := time.Since(t.refreshed) >= refreshInterval
+	t.mu.Unlock()
+	if due && t.refreshing.CompareAndSwap(false, true) {
+		go t.refresh()
+	}
+}
+
+// refresh rolls over ended periods, then reads the current spend of every scope from
+// Redis. The read happens without holding t.mu; scopes that rolled over meanwhile are
+// updated on the next refresh.
+func (t *Tracker) refresh() {
+	defer t.refreshing.Store(false)
+
+	now := time.Now().UTC()
+	t.mu.Lock()
+	t.refreshed = time.Now()
+	ids := make([]string, 0, len(t.scopes))
+	keys := make([]string, 0, 3*len(t.scopes))
+	for id, s := range t.scopes {
+		if s.rollOver(id, now) {
+			t.update(id, s)
+		}
+		ids = append(ids, id)
+		keys = append(keys, s.keys[:]...)
+	}
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	vals, err := t.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		// Keep the last known spend
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, id := range ids {
+		s, ok := t.scopes[id]
+		if !ok || s.keys != [3]string(keys[3*i:3*i+3]) {
+			continue
+		}
+		s.spend = Spend{
+			Hourly:  parseSpend(vals[3*i]),
+			Daily:   parseSpend(vals[3*i+1]),
+			Monthly: parseSpend(vals[3*i+2]),
+		}
+		t.update(id, s)
+	}
+}
+
+// update recomputes the state of a scope and reports transitions. Callers must hold t.mu.
+func (t *Tracker) update(id string, s *scope) {
+	state := OK
+	for _, p := range []struct{ spend, limit float64 }{
+		{s.spend.Hourly, s.limits.Hourly},
+		{s.spend.Daily, s.limits.Daily},
+		{s.spend.Monthly, s.limits.Monthly},
+	} {
+		switch {
+		case p.limit <= 0:
+		case p.spend >= p.limit:
+			state = Exhausted
+		case p.spend >= p.limit*s.limits.SoftRatio && state < Soft:
+			state = Soft
+		}
+	}
+
+	label := id
+	if id == globalScope {
+		label = "global"
+	}
+	metrics.BudgetState.WithLabelValues(label).Set(float64(state))
+	if state == s.state {
+		return
+	}
+	log.Printf("[BUDGET] Budget %s: %s -> %s (spend: $%.2f hourly, $%.2f daily, $%.2f monthly)",
+		label, s.state, state, s.spend.Hourly, s.spend.Daily, s.spend.Monthly)
+	s.state = state
+	s.since = time.Now()
+}
+
+// period is the Redis counter of one budget period
+type period struct {
+	key string
+	end time.Time
+}
+
+// periods returns the hourly, daily and monthly counters of a scope at now (UTC)
+func periods(id string, now time.Time) []period {
+	prefix := keyPrefix + "provider:" + id + ":"
+	if id == globalScope {
+		prefix = keyPrefix + "global:"
+	}
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return []period{
+		{key: prefix + "hourly:" + hour.Format("2006-01-02T15"), end: hour.Add(time.Hour)},
+		{key: prefix + "daily:" + day.Format("2006-01-02"), end: day.AddDate(0, 0, 1)},
+		{key: prefix + "monthly:" + month.Format("2006-01"), end: month.AddDate(0, 1, 0)},
+	}
+}
+
+func parseSpend(val interface{}) float64 {
+	s, ok := val.(string)
+	if !ok {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
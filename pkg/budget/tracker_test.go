@@ -0,0 +1,42 @@
+package budget
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScopeRollOver(t *testing.T) {
+	start := time.Date(2026, 1, 30, 22, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		rolled bool
+		want   Spend
+	}{
+		{"same hour", start.Add(20 * time.Minute), false, Spend{Hourly: 1, Daily: 2, Monthly: 3}},
+		{"next hour", start.Add(time.Hour), true, Spend{Daily: 2, Monthly: 3}},
+		{"next day", start.Add(2 * time.Hour), true, Spend{Monthly: 3}},
+		{"next month", start.Add(26 * time.Hour), true, Spend{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &scope{}
+			s.rollOver("test", start)
+			s.spend = Spend{Hourly: 1, Daily: 2, Monthly: 3}
+
+			if rolled := s.rollOver("test", tt.now); rolled != tt.rolled {
+				t.Errorf("rolled over = %v, want %v", rolled, tt.rolled)
+			}
+			if s.spend != tt.want {
+				t.Errorf("spend = %+v, want %+v", s.spend, tt.want)
+			}
+			for i, period := range periods("test", tt.now) {
+				if s.keys[i] != period.key {
+					t.Errorf("key %d = %s, want %s", i, s.keys[i], period.key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrMiss is returned by Get when a key is not cached
+var ErrMiss = errors.New("cache miss")
+
+// Backend stores cached values by key
+type Backend interface {
+	// Get returns the value stored under key, or ErrMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value under key for ttl (0 = no expiry)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// NewBackend creates the named cache backend ("redis" or "memory")
+func NewBackend(name string, redisClient *redis.Client, maxEntries int, maxBytes int64) (Backend, error) {
+	switch name {
+	case "", "redis":
+		return NewRedis(redisClient), nil
+	case "memory":
+		return NewMemory(maxEntries, maxBytes), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", name)
+	}
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"time"
+)
+
+// gzipMagic starts every gzip stream; uncompressed cache values never start with it
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Compressed gzips values of at least minSize bytes before storing them in the wrapped
+// backend. Smaller values are stored as they are; both kinds are read transparently.
+type Compressed struct {
+	backend Backend
+	minSize int
+}
+
+// NewCompressed wraps a backend with compression of large values
+func NewCompressed(backend Backend, minSize int) *Compressed {
+	return &Compressed{backend: backend, minSize: minSize}
+}
+
+// Get returns the value stored under key, decompressing it if needed, or ErrMiss
+func (c *Compressed) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := c.backend.Get(ctx, key)
+	if err != nil || !bytes.HasPrefix(val, gzipMagic) {
+		return val, err
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(val))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Set stores value under key for ttl (0 = no expiry), compressing it when large enough
+func (c *Compressed) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if len(value) < c.minSize {
+		return c.backend.Set(ctx, key, value, ttl)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.backend.Set(ctx, key, buf.Bytes(), ttl)
+}
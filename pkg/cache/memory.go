@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process LRU cache bounded by entry count and total value size.
+// It is local to one replica.
+type Memory struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	order      *list.List // front = most recently used
+	items      map[string]*list.Element
+}
+
+type memoryItem struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // zero = no expiry
+}
+
+// NewMemory creates an in-process cache. A limit of 0 leaves that dimension unbounded.
+func NewMemory(maxEntries int, maxBytes int64) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored under key, or ErrMiss
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	item := elem.Value.(*memoryItem)
+	if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
+		m.remove(elem)
+		return nil, ErrMiss
+	}
+
+	m.order.MoveToFront(elem)
+	return item.value, nil
+}
+
+// Set stores value under key for ttl (0 = no expiry), evicting the least recently
+// used entries until the cache is within its limits
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.items[key]; ok {
+		m.remove(elem)
+	}
+
+	// A value larger than the whole cache is never stored
+	if m.maxBytes > 0 && int64(len(value)) > m.maxBytes {
+		return nil
+	}
+
+	item := &memoryItem{key: key, value: value}
+	if ttl > 0 {
+		item.expiresAt = time.Now().Add(ttl)
+	}
+	m.items[key] = m.order.PushFront(item)
+	m.bytes += int64(len(value))
+
+	for (m.maxEntries > 0 && m.order.Len() > m.maxEntries) || (m.maxBytes > 0 && m.bytes > m.maxBytes) {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+// Len returns the number of cached entries and their total size in bytes
+func (m *Memory) Len() (int, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len(), m.bytes
+}
+
+// remove drops an entry; callers must hold m.mu
+func (m *Memory) remove(elem *list.Element) {
+	item := m.order.Remove(elem).(*memoryItem)
+	delete(m.items, item.key)
+	m.bytes -= int64(len(item.value))
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis stores cached values in Redis, shared by all replicas
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis creates a Redis cache backend
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+// Get returns the value stored under key, or ErrMiss
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := r.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	return val, err
+}
+
+// Set stores value under key for ttl (0 = no expiry)
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
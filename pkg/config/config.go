@@ -18,6 +18,8 @@ type Config struct {
 	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
 	Redis          RedisConfig          `yaml:"redis"`
 	Caching        CachingConfig        `yaml:"caching"`
+	Auth           AuthConfig           `yaml:"auth"`
+	Budget         BudgetConfig         `yaml:"budget"` // spend across all providers
 }
 
 // ServerConfig contains server settings
@@ -25,34 +27,251 @@ type ServerConfig struct {
 	Port         int           `yaml:"port"`
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// TrustedProxies lists the proxy IPs or CIDRs whose X-Forwarded-For header is used
+	// for the client IP; by default no proxy is trusted and the peer address is used
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 // ProviderConfig contains provider settings
 type ProviderConfig struct {
 	Name           string  `yaml:"name"`
 	URL            string  `yaml:"url"`
-	Priority       int     `yaml:"priority"`
+	WSURL          string  `yaml:"ws_url"`
+	Priority       int     `yaml:"priority"` // failover tier, lower values are preferred (default 1)
+	Backup         bool    `yaml:"backup"`   // used only when no other provider can serve
+	Weight         int     `yaml:"weight"`   // share of traffic under weighted round-robin (default 1)
 	CostPerRequest float64 `yaml:"cost_per_request"`
+	MaxBatchSize   int     `yaml:"max_batch_size"`
+
+	// MethodCosts prices methods in USD per request by exact name or "prefix*" pattern
+	// ("*" matches every method); other methods cost CostPerRequest
+	MethodCosts map[string]float64 `yaml:"method_costs"`
+
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
+	Budget         BudgetConfig         `yaml:"budget"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"` // unset values come from the global breaker
+}
+
+// BudgetConfig contains spend limits in USD per hour, day and month (0 = no limit).
+// Past SoftRatio of a limit traffic shifts to cheaper providers; at the limit a provider
+// is only used when no other provider is left.
+type BudgetConfig struct {
+	Hourly    float64 `yaml:"hourly" json:"hourly,omitempty"`
+	Daily     float64 `yaml:"daily" json:"daily,omitempty"`
+	Monthly   float64 `yaml:"monthly" json:"monthly,omitempty"`
+	SoftRatio float64 `yaml:"soft_ratio" json:"soft_ratio,omitempty"`
 }
 
-// HealthConfig contains health check settings
+// RateLimitConfig contains a provider's plan limits. Methods cost DefaultCredits unless
+// MethodCredits lists them by exact name or "prefix*" pattern. A rate of 0 is unlimited.
+type RateLimitConfig struct {
+	RequestsPerSecond float64            `yaml:"requests_per_second"`
+	CreditsPerSecond  float64            `yaml:"credits_per_second"`
+	DefaultCredits    float64            `yaml:"default_credits"`
+	MethodCredits     map[string]float64 `yaml:"method_credits"`
+}
+
+// HealthConfig contains health check settings. Probes that fail, or report the node
+// further behind than MaxSlotsBehind, count toward UnhealthyThreshold; slow probes and
+// nodes less far behind mark the provider degraded.
 type HealthConfig struct {
 	CheckInterval      time.Duration `yaml:"check_interval"`
-	Timeout            time.Duration `yaml:"timeout"`
-	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+	Timeout            time.Duration `yaml:"timeout"`             // probe timeout (default 5s)
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"` // consecutive failed probes (default 3)
+	HealthyThreshold   int           `yaml:"healthy_threshold"`   // consecutive good probes to recover (default 2)
+	DegradedLatency    time.Duration `yaml:"degraded_latency"`    // slower probes degrade (default half the timeout)
+	MaxSlotsBehind     uint64        `yaml:"max_slots_behind"`    // "node is behind" further than this fails (default 500)
+	DegradedWeight     float64       `yaml:"degraded_weight"`     // share of traffic a degraded provider keeps (default 0.25)
+	SlotLag            SlotLagConfig `yaml:"slot_lag"`
+	Outlier            OutlierConfig `yaml:"outlier_detection"`
+}
+
+// OutlierConfig contains settings for ejecting providers whose live traffic fails or
+// slows down far more than that of their peers. A provider is compared with the median
+// of the other providers that saw at least MinRequests attempts in the window.
+type OutlierConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	Window            time.Duration `yaml:"window"`              // default 30s
+	MinRequests       int           `yaml:"min_requests"`        // attempts needed in the window (default 20)
+	MinErrorRate      float64       `yaml:"min_error_rate"`      // lower error rates are never outliers (default 0.2)
+	ErrorRateFactor   float64       `yaml:"error_rate_factor"`   // times the peer median error rate (default 2)
+	MinLatency        time.Duration `yaml:"min_latency"`         // lower mean latencies are never outliers (default 250ms)
+	LatencyFactor     float64       `yaml:"latency_factor"`      // times the peer median mean latency (default 3)
+	BaseEjectionTime  time.Duration `yaml:"base_ejection_time"`  // doubles with each repeated ejection (default 30s)
+	MaxEjectionTime   time.Duration `yaml:"max_ejection_time"`   // default 5m
+	MaxEjectedPercent int           `yaml:"max_ejected_percent"` // share of the pool that may be ejected at once (default 50)
+}
+
+// SlotLagConfig contains settings for detecting providers that fall behind the cluster tip
+type SlotLagConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Commitments  []string      `yaml:"commitments"`
+	DemoteLag    uint64        `yaml:"demote_lag"`    // lagging providers are used only when no others are left
+	MaxLag       uint64        `yaml:"max_lag"`       // providers further behind are excluded
+	StallTimeout time.Duration `yaml:"stall_timeout"` // slot unchanged this long marks a provider stalled
 }
 
 // RoutingConfig contains routing settings
 type RoutingConfig struct {
-	Strategy     string        `yaml:"strategy"`
-	MaxRetries   int           `yaml:"max_retries"`
-	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	Strategy     string          `yaml:"strategy"` // pool default, see Strategies
+	MethodGroups []MethodGroup   `yaml:"method_groups"`
+	Tiers        TierConfig      `yaml:"tiers"`
+	MaxRetries   int             `yaml:"max_retries"`
+	RetryBackoff time.Duration   `yaml:"retry_backoff"`
+	Hedging      HedgingConfig   `yaml:"hedging"`
+	Rules        []RoutingRule   `yaml:"rules"`
+	Broadcast    BroadcastConfig `yaml:"broadcast"`
+
+	Consistency ConsistencyConfig `yaml:"consistency"`
+}
+
+// ConsistencyConfig contains settings for read-your-writes sessions. A session is
+// identified by the first of SessionHeaders present on the request, and its reads are
+// never answered from a slot older than the highest context slot it has already seen.
+type ConsistencyConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	SessionHeaders []string      `yaml:"session_headers"`
+	Mode           string        `yaml:"mode"` // "inject" (send minContextSlot upstream) or "retry" (reject stale responses)
+	SessionTTL     time.Duration `yaml:"session_ttl"`
+}
+
+// BroadcastConfig contains settings for sending transactions to several providers at once.
+// Retries of the same signed transaction are deduplicated by signature for DedupTTL.
+type BroadcastConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Methods   []string      `yaml:"methods"`
+	Providers []string      `yaml:"providers"` // empty = all healthy providers
+	DedupTTL  time.Duration `yaml:"dedup_ttl"`
+}
+
+// RoutingRule sends matching requests to a subset of providers with its own strategy.
+// Rules are evaluated in order; the fallback rule applies when no other rule matches.
+type RoutingRule struct {
+	Name      string    `yaml:"name"`
+	Match     RuleMatch `yaml:"match"`
+	Providers []string  `yaml:"providers"`
+	Strategy  string    `yaml:"strategy"`
+	Fallback  bool      `yaml:"fallback"`
+}
+
+// RuleMatch lists the conditions of a routing rule; all given conditions must hold
+type RuleMatch struct {
+	Methods []string          `yaml:"methods"` // exact names, or prefixes ending in "*"
+	Params  ParamMatch        `yaml:"params"`
+	Headers map[string]string `yaml:"headers"` // header -> value, "*" matches any value
+}
+
+// ParamMatch matches on the shape of the request params. The config object is the
+// by-name params object, or the trailing object of positional params.
+type ParamMatch struct {
+	MinCount int               `yaml:"min_count"`
+	HasKeys  []string          `yaml:"has_keys"`
+	Values   map[string]string `yaml:"values"`
+}
+
+// TierConfig contains settings for tiered failover by provider priority. Traffic stays on
+// the providers with the lowest priority value while at least MinProviders of them can
+// serve (healthy, within budget), and spills to the next tier only to make up the difference.
+type TierConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	MinProviders int  `yaml:"min_providers"`
+}
+
+// MethodGroup selects providers for a group of methods with its own strategy
+type MethodGroup struct {
+	Name     string   `yaml:"name"`
+	Methods  []string `yaml:"methods"` // exact names, or prefixes ending in "*"
+	Strategy string   `yaml:"strategy"`
+}
+
+// Strategies lists the provider selection strategies of the pool
+var Strategies = map[string]bool{
+	"round-robin":          true,
+	"weighted-round-robin": true,
+	"priority":             true,
+	"least-latency":        true,
+	"p2c":                  true, // power of two choices on EWMA latency
+	"cheapest":             true,
+}
+
+// RuleStrategies lists the provider selection strategies a routing rule may use
+var RuleStrategies = map[string]bool{
+	"":                     true, // pool default
+	"ordered":              true, // first candidate in rule order
+	"round-robin":          true,
+	"weighted-round-robin": true,
+	"priority":             true,
+	"least-latency":        true,
+	"p2c":                  true,
+	"cheapest":             true,
+}
+
+// MatchMethod reports whether a method matches any of the exact names or "prefix*" patterns
+func MatchMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(method, prefix) {
+				return true
+			}
+		} else if pattern == method {
+			return true
+		}
+	}
+	return false
 }
 
-// CircuitBreakerConfig contains circuit breaker settings
+// HedgingConfig contains settings for hedged requests: when the first provider has not
+// answered within the given latency percentile, a duplicate is sent to a second provider
+type HedgingConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Methods    []string      `yaml:"methods"`
+	Percentile float64       `yaml:"percentile"`
+	MinDelay   time.Duration `yaml:"min_delay"`
+	MaxDelay   time.Duration `yaml:"max_delay"`
+}
+
+// NonIdempotentMethods lists RPC methods with side effects that must never be duplicated
+var NonIdempotentMethods = map[string]bool{
+	"sendTransaction":    true,
+	"sendRawTransaction": true,
+	"requestAirdrop":     true,
+}
+
+// CircuitBreakerConfig contains circuit breaker settings. A breaker opens after
+// ConsecutiveFailures failures in a row, or once at least MinRequests attempts were made
+// in the current Interval and FailureRatio of them failed. After Timeout it lets
+// MaxRequests trial requests through, once a health probe has found the provider healthy.
 type CircuitBreakerConfig struct {
-	MaxRequests uint32        `yaml:"max_requests"`
-	Timeout     time.Duration `yaml:"timeout"`
+	MaxRequests         uint32        `yaml:"max_requests"`         // trial requests when half-open (default 5)
+	Timeout             time.Duration `yaml:"timeout"`              // time open before half-open (default 60s)
+	Interval            time.Duration `yaml:"interval"`             // failure counts reset this often while closed (default 60s)
+	ConsecutiveFailures uint32        `yaml:"consecutive_failures"` // default 5
+	FailureRatio        float64       `yaml:"failure_ratio"`        // 0 disables the ratio condition
+	MinRequests         uint32        `yaml:"min_requests"`         // attempts before the ratio applies (default 20)
+}
+
+// withDefaults fills the unset settings of a provider breaker from the global one
+func (b CircuitBreakerConfig) withDefaults(global CircuitBreakerConfig) CircuitBreakerConfig {
+	if b.MaxRequests == 0 {
+		b.MaxRequests = global.MaxRequests
+	}
+	if b.Timeout == 0 {
+		b.Timeout = global.Timeout
+	}
+	if b.Interval == 0 {
+		b.Interval = global.Interval
+	}
+	if b.ConsecutiveFailures == 0 {
+		b.ConsecutiveFailures = global.ConsecutiveFailures
+	}
+	if b.FailureRatio == 0 {
+		b.FailureRatio = global.FailureRatio
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = global.MinRequests
+	}
+	return b
 }
 
 // RedisConfig contains Redis settings
@@ -65,6 +284,95 @@ type RedisConfig struct {
 type CachingConfig struct {
 	Enabled bool                     `yaml:"enabled"`
 	Methods map[string]time.Duration `yaml:"methods"`
+
+	// CommitmentTTLs caps the TTL of results by request commitment
+	// (processed/confirmed/finalized); a cap of 0 disables caching at that commitment
+	CommitmentTTLs map[string]time.Duration `yaml:"commitment_ttls"`
+
+	// Immutable lists methods whose finalized, non-null results never change,
+	// with their TTL (0 = no expiry)
+	Immutable map[string]time.Duration `yaml:"immutable"`
+
+	// Stale lists per-method policies for serving expired entries
+	Stale map[string]StalePolicy `yaml:"stale"`
+
+	// Negative caches deterministic error results for a short time
+	Negative NegativeCacheConfig `yaml:"negative"`
+
+	Coalescing CoalescingConfig `yaml:"coalescing"`
+
+	// Backend is the shared cache store: "redis" (default) or "memory" (in-process
+	// only, bounded by the L1 limits; the L1 tier is then skipped)
+	Backend     string            `yaml:"backend"`
+	L1          L1CacheConfig     `yaml:"l1"`
+	Compression CompressionConfig `yaml:"compression"`
+}
+
+// L1CacheConfig contains settings for the in-process LRU cache in front of the backend.
+// Entries are kept for at most MaxTTL, or the method's entry in MethodTTLs (0 = not kept).
+type L1CacheConfig struct {
+	Enabled    bool                     `yaml:"enabled"`
+	MaxEntries int                      `yaml:"max_entries"`
+	MaxBytes   int64                    `yaml:"max_bytes"`
+	MaxTTL     time.Duration            `yaml:"max_ttl"`
+	MethodTTLs map[string]time.Duration `yaml:"method_ttls"`
+}
+
+// CompressionConfig contains settings for gzip compression of large cache values
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MinSize int  `yaml:"min_size"` // bytes
+}
+
+// StalePolicy controls how long after expiry a cached entry may still be served.
+// StaleWhileRevalidate serves it while a background request refreshes it;
+// StaleIfError serves it when the request cannot be answered upstream.
+type StalePolicy struct {
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+	StaleIfError         time.Duration `yaml:"stale_if_error"`
+}
+
+// NegativeCacheConfig contains settings for caching error results whose code is known
+// to be deterministic, such as an invalid param or an account that does not exist
+type NegativeCacheConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	Codes   []int         `yaml:"codes"`
+}
+
+// CoalescingConfig contains settings for sharing one upstream call between identical
+// in-flight cacheable requests. Distributed coalescing extends this across replicas
+// with a Redis lock held for at most LockTTL.
+type CoalescingConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Distributed bool          `yaml:"distributed"`
+	LockTTL     time.Duration `yaml:"lock_ttl"`
+}
+
+// AuthConfig contains settings for client API keys. A key is sent in Header or as the
+// path segment of /rpc/<key>, and is looked up in Keys, then in Redis under "apikey:<key>".
+// Requests without a key are served as "anonymous" unless Required is set.
+type AuthConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Required bool              `yaml:"required"`
+	Header   string            `yaml:"header"`
+	Keys     []ClientKeyConfig `yaml:"keys"`
+
+	// IPRequestsPerSecond limits every client IP, with or without a key (0 = unlimited)
+	IPRequestsPerSecond float64 `yaml:"ip_requests_per_second"`
+}
+
+// ClientKeyConfig describes one client API key and its limits (0 = unlimited).
+// Keys stored in Redis use the same fields as JSON.
+type ClientKeyConfig struct {
+	Name              string  `yaml:"name" json:"name"`
+	Key               string  `yaml:"key" json:"-"`
+	RequestsPerSecond float64 `yaml:"requests_per_second" json:"requests_per_second"`
+	DailyQuota        int64   `yaml:"daily_quota" json:"daily_quota"`
+	MonthlyQuota      int64   `yaml:"monthly_quota" json:"monthly_quota"`
+
+	// Admin keys may also change routing at runtime, e.g. switch strategies
+	Admin bool `yaml:"admin" json:"admin"`
 }
 
 // Load reads and parses the configuration file
@@ -102,6 +410,10 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("at least one provider must be configured")
 	}
 
+	if err := c.CircuitBreaker.validate(); err != nil {
+		return fmt.Errorf("circuit_breaker: %w", err)
+	}
+
 	for i, p := range c.Providers {
 		if p.Name == "" {
 			return fmt.Errorf("provider %d: name is required", i)
@@ -112,14 +424,502 @@ func (c *Config) Validate() error {
 		if !strings.HasPrefix(p.URL, "http://") && !strings.HasPrefix(p.URL, "https://") {
 			return fmt.Errorf("provider %s: URL must start with http:// or https://", p.Name)
 		}
+		if p.WSURL != "" && !strings.HasPrefix(p.WSURL, "ws://") && !strings.HasPrefix(p.WSURL, "wss://") {
+			return fmt.Errorf("provider %s: ws_url must start with ws:// or wss://", p.Name)
+		}
 		if p.CostPerRequest < 0 {
 			return fmt.Errorf("provider %s: cost_per_request must be non-negative", p.Name)
 		}
+		if p.MaxBatchSize < 0 {
+			return fmt.Errorf("provider %s: max_batch_size must be non-negative", p.Name)
+		}
+		if p.Weight < 0 || p.Priority < 0 {
+			return fmt.Errorf("provider %s: weight and priority must be non-negative", p.Name)
+		}
+		if p.Priority == 0 {
+			c.Providers[i].Priority = 1
+		}
+		if p.Weight == 0 {
+			c.Providers[i].Weight = 1
+		}
+		for method, cost := range p.MethodCosts {
+			if cost < 0 {
+				return fmt.Errorf("provider %s: cost of %s must be non-negative", p.Name, method)
+			}
+		}
+		if p.RateLimit.RequestsPerSecond < 0 || p.RateLimit.CreditsPerSecond < 0 || p.RateLimit.DefaultCredits < 0 {
+			return fmt.Errorf("provider %s: rate_limit values must be non-negative", p.Name)
+		}
+		if p.RateLimit.DefaultCredits == 0 {
+			c.Providers[i].RateLimit.DefaultCredits = 1
+		}
+		if err := c.Providers[i].Budget.validate(); err != nil {
+			return fmt.Errorf("provider %s: budget: %w", p.Name, err)
+		}
+		c.Providers[i].CircuitBreaker = p.CircuitBreaker.withDefaults(c.CircuitBreaker)
+		if err := c.Providers[i].CircuitBreaker.validate(); err != nil {
+			return fmt.Errorf("provider %s: circuit_breaker: %w", p.Name, err)
+		}
+	}
+
+	if err := c.Budget.validate(); err != nil {
+		return fmt.Errorf("budget: %w", err)
 	}
 
 	if c.Routing.MaxRetries < 0 {
 		return fmt.Errorf("max_retries must be non-negative")
 	}
 
+	if err := c.Routing.validateStrategies(); err != nil {
+		return err
+	}
+
+	if err := c.Routing.Hedging.validate(); err != nil {
+		return fmt.Errorf("hedging: %w", err)
+	}
+
+	if err := c.Routing.Consistency.validate(); err != nil {
+		return fmt.Errorf("consistency: %w", err)
+	}
+
+	if err := c.Health.validate(); err != nil {
+		return fmt.Errorf("health: %w", err)
+	}
+
+	if err := c.Health.SlotLag.validate(); err != nil {
+		return fmt.Errorf("slot_lag: %w", err)
+	}
+
+	for commitment := range c.Caching.CommitmentTTLs {
+		switch commitment {
+		case "processed", "confirmed", "finalized":
+		default:
+			return fmt.Errorf("caching: unknown commitment %q", commitment)
+		}
+	}
+
+	for method, policy := range c.Caching.Stale {
+		if policy.StaleWhileRevalidate < 0 || policy.StaleIfError < 0 {
+			return fmt.Errorf("caching: stale policy of %s must be non-negative", method)
+		}
+	}
+
+	if c.Caching.Negative.Enabled {
+		if c.Caching.Negative.TTL <= 0 {
+			c.Caching.Negative.TTL = 5 * time.Second
+		}
+		if len(c.Caching.Negative.Codes) == 0 {
+			// Invalid params (incl. unknown accounts), skipped slots, slots missing from storage
+			c.Caching.Negative.Codes = []int{-32602, -32007, -32009}
+		}
+	}
+
+	if err := c.Caching.validateTiers(); err != nil {
+		return fmt.Errorf("caching: %w", err)
+	}
+
+	if c.Caching.Coalescing.Distributed && c.Caching.Coalescing.LockTTL == 0 {
+		c.Caching.Coalescing.LockTTL = 250 * time.Millisecond
+	}
+
+	if err := c.validateRules(); err != nil {
+		return err
+	}
+
+	if err := c.validateBroadcast(); err != nil {
+		return fmt.Errorf("broadcast: %w", err)
+	}
+
+	if err := c.Auth.validate(); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+
+	return nil
+}
+
+// validate checks hedging settings and fills in defaults
+func (h *HedgingConfig) validate() error {
+	if !h.Enabled {
+		return nil
+	}
+
+	for _, method := range h.Methods {
+		if NonIdempotentMethods[method] {
+			return fmt.Errorf("method %s is not idempotent and cannot be hedged", method)
+		}
+	}
+
+	if h.Percentile == 0 {
+		h.Percentile = 0.95
+	}
+	if h.Percentile <= 0 || h.Percentile >= 1 {
+		return fmt.Errorf("percentile must be between 0 and 1")
+	}
+
+	if h.MaxDelay == 0 {
+		h.MaxDelay = time.Second
+	}
+	if h.MinDelay < 0 || h.MinDelay > h.MaxDelay {
+		return fmt.Errorf("min_delay must be between 0 and max_delay")
+	}
+
+	return nil
+}
+
+// validateRules checks routing rules against the configured providers
+func (c *Config) validateRules() error {
+	known := make(map[string]bool, len(c.Providers))
+	for _, p := range c.Providers {
+		known[p.Name] = true
+	}
+
+	names := make(map[string]bool)
+	fallbacks := 0
+	for i, rule := range c.Routing.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("routing rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("routing rule %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if !RuleStrategies[rule.Strategy] {
+			return fmt.Errorf("routing rule %s: unknown strategy %q", rule.Name, rule.Strategy)
+		}
+		for _, name := range rule.Providers {
+			if !known[name] {
+				return fmt.Errorf("routing rule %s: unknown provider %s", rule.Name, name)
+			}
+		}
+		if rule.Fallback {
+			fallbacks++
+		}
+	}
+
+	if fallbacks > 1 {
+		return fmt.Errorf("at most one routing rule can be the fallback")
+	}
+
+	return nil
+}
+
+// validateBroadcast checks broadcast settings and fills in defaults
+func (c *Config) validateBroadcast() error {
+	b := &c.Routing.Broadcast
+	if !b.Enabled {
+		return nil
+	}
+
+	if len(b.Methods) == 0 {
+		b.Methods = []string{"sendTransaction", "sendRawTransaction"}
+	}
+	if b.DedupTTL == 0 {
+		b.DedupTTL = 2 * time.Minute
+	}
+	if b.DedupTTL < 0 {
+		return fmt.Errorf("dedup_ttl must be positive")
+	}
+
+	known := make(map[string]bool, len(c.Providers))
+	for _, p := range c.Providers {
+		known[p.Name] = true
+	}
+	for _, name := range b.Providers {
+		if !known[name] {
+			return fmt.Errorf("unknown provider %s", name)
+		}
+	}
+
+	return nil
+}
+
+// validate checks circuit breaker settings and fills in defaults
+func (b *CircuitBreakerConfig) validate() error {
+	if b.MaxRequests == 0 {
+		b.MaxRequests = 5
+	}
+	if b.Timeout <= 0 {
+		b.Timeout = time.Minute
+	}
+	if b.Interval <= 0 {
+		b.Interval = time.Minute
+	}
+	if b.ConsecutiveFailures == 0 {
+		b.ConsecutiveFailures = 5
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = 20
+	}
+	if b.FailureRatio < 0 || b.FailureRatio > 1 {
+		return fmt.Errorf("failure_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// validate checks health check settings and fills in defaults
+func (h *HealthConfig) validate() error {
+	if h.CheckInterval <= 0 {
+		h.CheckInterval = 10 * time.Second
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = 5 * time.Second
+	}
+	if h.UnhealthyThreshold <= 0 {
+		h.UnhealthyThreshold = 3
+	}
+	if h.HealthyThreshold <= 0 {
+		h.HealthyThreshold = 2
+	}
+	if h.DegradedLatency <= 0 {
+		h.DegradedLatency = h.Timeout / 2
+	}
+	if h.MaxSlotsBehind == 0 {
+		h.MaxSlotsBehind = 500
+	}
+	if h.DegradedWeight == 0 {
+		h.DegradedWeight = 0.25
+	}
+	if h.DegradedWeight < 0 || h.DegradedWeight > 1 {
+		return fmt.Errorf("degraded_weight must be between 0 and 1")
+	}
+	if err := h.Outlier.validate(); err != nil {
+		return fmt.Errorf("outlier_detection: %w", err)
+	}
+	return nil
+}
+
+// validate checks outlier detection settings and fills in defaults
+func (o *OutlierConfig) validate() error {
+	if !o.Enabled {
+		return nil
+	}
+
+	if o.Window <= 0 {
+		o.Window = 30 * time.Second
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = 20
+	}
+	if o.MinErrorRate <= 0 {
+		o.MinErrorRate = 0.2
+	}
+	if o.ErrorRateFactor <= 0 {
+		o.ErrorRateFactor = 2
+	}
+	if o.MinLatency <= 0 {
+		o.MinLatency = 250 * time.Millisecond
+	}
+	if o.LatencyFactor <= 0 {
+		o.LatencyFactor = 3
+	}
+	if o.BaseEjectionTime <= 0 {
+		o.BaseEjectionTime = 30 * time.Second
+	}
+	if o.MaxEjectionTime <= 0 {
+		o.MaxEjectionTime = 5 * time.Minute
+	}
+	if o.MaxEjectionTime < o.BaseEjectionTime {
+		return fmt.Errorf("max_ejection_time must not be below base_ejection_time")
+	}
+	if o.MaxEjectedPercent == 0 {
+		o.MaxEjectedPercent = 50
+	}
+	if o.MaxEjectedPercent < 0 || o.MaxEjectedPercent > 100 {
+		return fmt.Errorf("max_ejected_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// validate checks slot lag settings and fills in defaults
+func (s *SlotLagConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+
+	if len(s.Commitments) == 0 {
+		s.Commitments = []string{"confirmed"}
+	}
+	for _, commitment := range s.Commitments {
+		switch commitment {
+		case "processed", "confirmed", "finalized":
+		default:
+			return fmt.Errorf("unknown commitment %q", commitment)
+		}
+	}
+
+	if s.MaxLag == 0 {
+		s.MaxLag = 150
+	}
+	if s.DemoteLag > s.MaxLag {
+		return fmt.Errorf("demote_lag must not exceed max_lag")
+	}
+	if s.StallTimeout == 0 {
+		s.StallTimeout = 30 * time.Second
+	}
+
+	return nil
+}
+
+// validate checks session consistency settings and fills in defaults
+func (s *ConsistencyConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+
+	if len(s.SessionHeaders) == 0 {
+		s.SessionHeaders = []string{"X-API-Key", "X-Session-Id"}
+	}
+
+	switch s.Mode {
+	case "":
+		s.Mode = "retry"
+	case "inject", "retry":
+	default:
+		return fmt.Errorf("unknown mode %q (expected inject or retry)", s.Mode)
+	}
+
+	if s.SessionTTL == 0 {
+		s.SessionTTL = 10 * time.Minute
+	}
+
+	return nil
+}
+
+// validateTiers checks the cache backend, L1 and compression settings and fills in defaults
+func (c *CachingConfig) validateTiers() error {
+	switch c.Backend {
+	case "":
+		c.Backend = "redis"
+	case "redis":
+	case "memory":
+		// The backend is already in process, so a second in-process tier adds nothing
+		c.L1.Enabled = false
+	default:
+		return fmt.Errorf("unknown backend %q (expected redis or memory)", c.Backend)
+	}
+
+	if c.L1.MaxEntries < 0 || c.L1.MaxBytes < 0 {
+		return fmt.Errorf("l1 limits must be non-negative")
+	}
+	if c.L1.MaxEntries == 0 {
+		c.L1.MaxEntries = 10000
+	}
+	if c.L1.MaxBytes == 0 {
+		c.L1.MaxBytes = 64 << 20
+	}
+	if c.L1.MaxTTL == 0 {
+		c.L1.MaxTTL = time.Second
+	}
+
+	if c.Compression.Enabled && c.Compression.MinSize <= 0 {
+		c.Compression.MinSize = 4096
+	}
+
+	return nil
+}
+
+// validate checks client API keys and fills in defaults
+func (a *AuthConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+
+	if a.Header == "" {
+		a.Header = "X-API-Key"
+	}
+	if a.IPRequestsPerSecond < 0 {
+		return fmt.Errorf("ip_requests_per_second must be non-negative")
+	}
+
+	names := make(map[string]bool, len(a.Keys))
+	keys := make(map[string]bool, len(a.Keys))
+	for i, k := range a.Keys {
+		if k.Name == "" || k.Key == "" {
+			return fmt.Errorf("key %d: name and key are required", i)
+		}
+		if k.Name == "anonymous" {
+			return fmt.Errorf("key name %q is reserved for requests without a key", k.Name)
+		}
+		if names[k.Name] || keys[k.Key] {
+			return fmt.Errorf("key %s: duplicate name or key", k.Name)
+		}
+		names[k.Name] = true
+		keys[k.Key] = true
+
+		if k.RequestsPerSecond < 0 || k.DailyQuota < 0 || k.MonthlyQuota < 0 {
+			return fmt.Errorf("key %s: limits must be non-negative", k.Name)
+		}
+	}
+
+	return nil
+}
+
+// MethodValue looks up a method in a table keyed by exact method name or "prefix*"
+// pattern. An exact match wins, then the longest matching pattern.
+func MethodValue(values map[string]float64, method string) (float64, bool) {
+	if v, ok := values[method]; ok {
+		return v, true
+	}
+	best, bestLen := 0.0, -1
+	for pattern, v := range values {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(method, prefix) && len(prefix) > bestLen {
+			best, bestLen = v, len(prefix)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// Limited reports whether any spend limit is set
+func (b BudgetConfig) Limited() bool {
+	return b.Hourly > 0 || b.Daily > 0 || b.Monthly > 0
+}
+
+// validate checks spend limits and fills in defaults
+func (b *BudgetConfig) validate() error {
+	if b.Hourly < 0 || b.Daily < 0 || b.Monthly < 0 {
+		return fmt.Errorf("limits must be non-negative")
+	}
+	if b.SoftRatio == 0 {
+		b.SoftRatio = 0.8
+	}
+	if b.SoftRatio < 0 || b.SoftRatio > 1 {
+		return fmt.Errorf("soft_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// validateStrategies checks the pool and method group strategies and fills in defaults
+func (r *RoutingConfig) validateStrategies() error {
+	if r.Strategy == "" {
+		r.Strategy = "least-latency"
+	}
+	if r.Tiers.MinProviders < 0 {
+		return fmt.Errorf("tiers: min_providers must be non-negative")
+	}
+	if r.Tiers.MinProviders == 0 {
+		r.Tiers.MinProviders = 1
+	}
+	if !Strategies[r.Strategy] {
+		return fmt.Errorf("unknown routing strategy %q", r.Strategy)
+	}
+
+	names := make(map[string]bool, len(r.MethodGroups))
+	for i, group := range r.MethodGroups {
+		if group.Name == "" {
+			return fmt.Errorf("method group %d: name is required", i)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("method group %s: duplicate name", group.Name)
+		}
+		names[group.Name] = true
+		if len(group.Methods) == 0 {
+			return fmt.Errorf("method group %s: at least one method is required", group.Name)
+		}
+		if !Strategies[group.Strategy] {
+			return fmt.Errorf("method group %s: unknown strategy %q", group.Name, group.Strategy)
+		}
+	}
+
 	return nil
 }
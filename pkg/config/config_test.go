@@ -0,0 +1,58 @@
+package config
+
+import "testing"
+
+func TestMethodValue(t *testing.T) {
+	values := map[string]float64{
+		"getProgramAccounts": 10,
+		"get*":               1,
+		"getProgram*":        5,
+		"getTokenAccounts*":  3,
+		"send*":              2,
+	}
+
+	tests := []struct {
+		method string
+		want   float64
+		found  bool
+	}{
+		{"getProgramAccounts", 10, true},  // exact match beats every pattern
+		{"getProgramAccountsV2", 5, true}, // longest pattern wins
+		{"getTokenAccountsByOwner", 3, true},
+		{"getBalance", 1, true},
+		{"sendTransaction", 2, true},
+		{"get", 1, true}, // a pattern matches its bare prefix
+		{"simulateTransaction", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, found := MethodValue(values, tt.method)
+		if got != tt.want || found != tt.found {
+			t.Errorf("MethodValue(%q) = %v, %v, want %v, %v", tt.method, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestMethodValueCatchAll(t *testing.T) {
+	values := map[string]float64{"*": 0.5, "getSlot": 0}
+
+	tests := []struct {
+		method string
+		want   float64
+	}{
+		{"getSlot", 0}, // an exact zero is still a match
+		{"getBalance", 0.5},
+	}
+
+	for _, tt := range tests {
+		got, found := MethodValue(values, tt.method)
+		if !found || got != tt.want {
+			t.Errorf("MethodValue(%q) = %v, %v, want %v, true", tt.method, got, found, tt.want)
+		}
+	}
+
+	if _, found := MethodValue(nil, "getSlot"); found {
+		t.Errorf("MethodValue(nil) found a value")
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
 )
@@ -19,29 +22,59 @@ const (
 
 // HealthMonitor probes providers periodically and stores their status in Redis
 type HealthMonitor struct {
+	redis  *redis.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	ticker *time.Ticker
+
+	// Targets and settings, swapped by Reload. The probe state below is only used
+	// by checkAll, which holds mu while it applies probe results.
+	mu        sync.Mutex
 	providers []provider.Provider
-	redis     *redis.Client
+	config    config.HealthConfig
 	interval  time.Duration
-	ctx       context.Context
-	cancel    context.CancelFunc
+	slotLag   config.SlotLagConfig
+
+	// Stall detection: last slot seen per provider and commitment, and when it last advanced
+	lastSlots   map[string]uint64
+	lastAdvance map[string]time.Time
+
+	// Probe history per provider, for the state thresholds
+	histories map[string]*probeHistory
+}
+
+// probeHistory is the state of a provider and its streaks of probe outcomes
+type probeHistory struct {
+	state    string
+	failures int // consecutive failed probes
+	passes   int // consecutive probes that did not fail
+	goods    int // consecutive healthy probes
 }
 
 // NewHealthMonitor creates a new health monitor
-func NewHealthMonitor(providers []provider.Provider, redisClient *redis.Client, interval time.Duration) *HealthMonitor {
+func NewHealthMonitor(providers []provider.Provider, redisClient *redis.Client, cfg config.HealthConfig) *HealthMonitor {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &HealthMonitor{
-		providers: providers,
-		redis:     redisClient,
-		interval:  interval,
-		ctx:       ctx,
-		cancel:    cancel,
+		providers:   providers,
+		redis:       redisClient,
+		config:      cfg,
+		interval:    cfg.CheckInterval,
+		slotLag:     cfg.SlotLag,
+		ctx:         ctx,
+		cancel:      cancel,
+		lastSlots:   make(map[string]uint64),
+		lastAdvance: make(map[string]time.Time),
+		histories:   make(map[string]*probeHistory),
 	}
 }
 
 // Start begins the background health monitoring
 func (m *HealthMonitor) Start() {
+	m.mu.Lock()
 	log.Printf("[HEALTH] Starting health monitor with interval %v", m.interval)
 	ticker := time.NewTicker(m.interval)
+	m.ticker = ticker
+	m.mu.Unlock()
 
 	// Initial check
 	m.checkAll()
@@ -64,38 +97,226 @@ func (m *HealthMonitor) Stop() {
 	m.cancel()
 }
 
-func (m *HealthMonitor) checkAll() {
+// Reload swaps the probed providers and the health settings. Providers that are still
+// probed keep their state and probe streaks; a new interval applies from the next tick.
+func (m *HealthMonitor) Reload(providers []provider.Provider, cfg config.HealthConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		keep[p.Name()] = true
+	}
 	for _, p := range m.providers {
-		go m.checkProvider(p)
+		if !keep[p.Name()] {
+			delete(m.histories, p.Name())
+			metrics.ProviderHealthStatus.DeleteLabelValues(p.Name())
+		}
+	}
+
+	m.providers = providers
+	m.config = cfg
+	m.slotLag = cfg.SlotLag
+	if cfg.CheckInterval != m.interval {
+		m.interval = cfg.CheckInterval
+		if m.ticker != nil {
+			m.ticker.Reset(m.interval)
+		}
+		log.Printf("[HEALTH] Health check interval set to %v", m.interval)
+	}
+}
+
+// checkAll probes every provider concurrently, then computes slot lag against the
+// cluster tip (the highest slot any provider reports) and publishes the results
+func (m *HealthMonitor) checkAll() {
+	m.mu.Lock()
+	providers, cfg := m.providers, m.config
+	m.mu.Unlock()
+
+	statuses := make([]*provider.HealthStatus, len(providers))
+
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		wg.Add(1)
+		go func(i int, p provider.Provider) {
+			defer wg.Done()
+			statuses[i] = m.checkProvider(p, cfg)
+		}(i, p)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slotLag.Enabled {
+		m.computeSlotLag(providers, statuses)
+	}
+
+	for i, p := range providers {
+		status := statuses[i]
+		if status == nil {
+			continue
+		}
+		m.applyState(p.Name(), status)
+
+		// Update Redis
+		if err := m.updateStatus(p.Name(), status); err != nil {
+			log.Printf("[HEALTH] Error updating status in Redis for %s: %v", p.Name(), err)
+		}
 	}
 }
 
-func (m *HealthMonitor) checkProvider(p provider.Provider) {
-	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+func (m *HealthMonitor) checkProvider(p provider.Provider, cfg config.HealthConfig) *provider.HealthStatus {
+	ctx, cancel := context.WithTimeout(m.ctx, cfg.Timeout)
 	defer cancel()
 
 	status, err := p.CheckHealth(ctx)
 	if err != nil {
 		log.Printf("[HEALTH] Error checking provider %s: %v", p.Name(), err)
-		// Update metrics
-		metrics.ProviderHealthStatus.WithLabelValues(p.Name()).Set(0)
-		return
+		status = &provider.HealthStatus{LastCheck: time.Now(), ErrorMessage: err.Error()}
+	}
+
+	// Sample slots per commitment
+	if cfg.SlotLag.Enabled {
+		status.Slots = make(map[string]uint64, len(cfg.SlotLag.Commitments))
+		for _, commitment := range cfg.SlotLag.Commitments {
+			slot, err := p.GetSlot(ctx, commitment)
+			if err != nil {
+				log.Printf("[HEALTH] Error sampling %s slot from %s: %v", commitment, p.Name(), err)
+				continue
+			}
+			status.Slots[commitment] = slot
+			metrics.ProviderSlot.WithLabelValues(p.Name(), commitment).Set(float64(slot))
+		}
 	}
 
-	// Update Prometheus metrics
+	return status
+}
+
+// classify returns what a single probe says about a provider. A node that is behind
+// but still within MaxSlotsBehind, or that answers slowly, is degraded.
+func (m *HealthMonitor) classify(status *provider.HealthStatus) (string, string) {
+	switch {
+	case status.SlotsBehind > 0 && status.SlotsBehind <= m.config.MaxSlotsBehind:
+		return provider.Degraded, fmt.Sprintf("node is behind by %d slots", status.SlotsBehind)
+	case !status.Healthy:
+		return provider.Unhealthy, status.ErrorMessage
+	case time.Duration(status.LatencyMs)*time.Millisecond > m.config.DegradedLatency:
+		return provider.Degraded, fmt.Sprintf("probe took %dms", status.LatencyMs)
+	}
+	return provider.Healthy, "probe succeeded"
+}
+
+// applyState moves a provider between states based on the latest probe. A failed probe
+// degrades a healthy provider, UnhealthyThreshold failures in a row make it unhealthy,
+// and HealthyThreshold probes in a row are needed to recover, so a flapping provider
+// does not bounce between states.
+func (m *HealthMonitor) applyState(name string, status *provider.HealthStatus) {
+	outcome, reason := m.classify(status)
+
+	h, ok := m.histories[name]
+	if !ok {
+		h = &probeHistory{}
+		m.histories[name] = h
+	}
+	if outcome == provider.Unhealthy {
+		h.failures++
+		h.passes, h.goods = 0, 0
+	} else {
+		h.failures = 0
+		h.passes++
+		if outcome == provider.Healthy {
+			h.goods++
+		} else {
+			h.goods = 0
+		}
+	}
+
+	prev, state := h.state, h.state
+	switch {
+	case prev == "":
+		// First probe since start
+		state = outcome
+	case h.failures >= m.config.UnhealthyThreshold:
+		state = provider.Unhealthy
+	case prev == provider.Unhealthy:
+		if h.goods >= m.config.HealthyThreshold {
+			state = provider.Healthy
+		} else if h.passes >= m.config.HealthyThreshold {
+			state = provider.Degraded
+		}
+	case outcome != provider.Healthy:
+		state = provider.Degraded
+	case prev == provider.Degraded && h.goods >= m.config.HealthyThreshold:
+		state = provider.Healthy
+	}
+	h.state = state
+
+	status.State = state
+	status.Healthy = state != provider.Unhealthy
+	status.ConsecutiveFailures = h.failures
+
 	healthVal := 1.0
-	if !status.Healthy {
-		healthVal = 0.0
+	switch state {
+	case provider.Degraded:
+		healthVal = 0.5
+	case provider.Unhealthy:
+		healthVal = 0
+	}
+	metrics.ProviderHealthStatus.WithLabelValues(name).Set(healthVal)
+
+	if state != prev {
+		log.Printf("[HEALTH] Provider %s is %s: %s", name, strings.ToUpper(state), reason)
 	}
-	metrics.ProviderHealthStatus.WithLabelValues(p.Name()).Set(healthVal)
+}
 
-	// Update Redis
-	if err := m.updateStatus(p.Name(), status); err != nil {
-		log.Printf("[HEALTH] Error updating status in Redis for %s: %v", p.Name(), err)
+// computeSlotLag fills in SlotLag and Stalled for every sampled provider.
+// Callers must hold m.mu.
+func (m *HealthMonitor) computeSlotLag(providers []provider.Provider, statuses []*provider.HealthStatus) {
+	tips := make(map[string]uint64)
+	for _, status := range statuses {
+		if status == nil {
+			continue
+		}
+		for commitment, slot := range status.Slots {
+			if slot > tips[commitment] {
+				tips[commitment] = slot
+			}
+		}
 	}
 
-	if !status.Healthy {
-		log.Printf("[HEALTH] Provider %s is UNHEALTHY: %s", p.Name(), status.ErrorMessage)
+	now := time.Now()
+	for i, p := range providers {
+		status := statuses[i]
+		if status == nil {
+			continue
+		}
+
+		for commitment, slot := range status.Slots {
+			lag := tips[commitment] - slot
+			metrics.ProviderSlotLag.WithLabelValues(p.Name(), commitment).Set(float64(lag))
+			if lag > status.SlotLag {
+				status.SlotLag = lag
+			}
+
+			// A provider is stalled when its slot has not moved for StallTimeout
+			key := p.Name() + ":" + commitment
+			if slot != m.lastSlots[key] {
+				m.lastSlots[key] = slot
+				m.lastAdvance[key] = now
+			} else if now.Sub(m.lastAdvance[key]) > m.slotLag.StallTimeout {
+				status.Stalled = true
+			}
+		}
+
+		stalledVal := 0.0
+		if status.Stalled {
+			stalledVal = 1.0
+			log.Printf("[HEALTH] Provider %s is STALLED (slot not advancing for %v)", p.Name(), m.slotLag.StallTimeout)
+		} else if status.SlotLag > m.slotLag.DemoteLag {
+			log.Printf("[HEALTH] Provider %s is LAGGING by %d slots", p.Name(), status.SlotLag)
+		}
+		metrics.ProviderStalled.WithLabelValues(p.Name()).Set(stalledVal)
 	}
 }
 
@@ -114,8 +335,13 @@ func (m *HealthMonitor) updateStatus(name string, status *provider.HealthStatus)
 	return nil
 }
 
-// GetProviderStatus retrieves the health status of a provider from Redis
+// GetProviderStatus retrieves the health status of a provider from Redis. Without a
+// Redis client there is no status, as if the provider had not been probed yet.
 func GetProviderStatus(ctx context.Context, redisClient *redis.Client, name string) (*provider.HealthStatus, error) {
+	if redisClient == nil {
+		return nil, nil
+	}
+
 	key := healthKeyPrefix + name
 	data, err := redisClient.Get(ctx, key).Bytes()
 	if err != nil {
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// Probe results, by name
+var probes = map[string]provider.HealthStatus{
+	"ok":     {Healthy: true, LatencyMs: 10},
+	"slow":   {Healthy: true, LatencyMs: 300},
+	"behind": {Healthy: false, SlotsBehind: 100, ErrorMessage: "Node is behind by 100 slots"},
+	"far":    {Healthy: false, SlotsBehind: 1000, ErrorMessage: "Node is behind by 1000 slots"},
+	"down":   {Healthy: false, ErrorMessage: "connection refused"},
+}
+
+func testConfig() config.HealthConfig {
+	return config.HealthConfig{
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   2,
+		DegradedLatency:    200 * time.Millisecond,
+		MaxSlotsBehind:     500,
+		SlotLag:            config.SlotLagConfig{StallTimeout: 50 * time.Millisecond},
+	}
+}
+
+func TestApplyState(t *testing.T) {
+	const (
+		healthy   = provider.Healthy
+		degraded  = provider.Degraded
+		unhealthy = provider.Unhealthy
+	)
+
+	tests := []struct {
+		name   string
+		probes []string
+		want   []string // state after each probe
+	}{
+		{"first probe healthy", []string{"ok"}, []string{healthy}},
+		{"first probe slow", []string{"slow"}, []string{degraded}},
+		{"first probe down", []string{"down"}, []string{unhealthy}},
+		{
+			name:   "failures degrade, then make unhealthy",
+			probes: []string{"ok", "down", "down", "down"},
+			want:   []string{healthy, degraded, degraded, unhealthy},
+		},
+		{
+			name:   "recovery needs consecutive good probes",
+			probes: []string{"down", "ok", "down", "ok", "ok"},
+			want:   []string{unhealthy, unhealthy, unhealthy, unhealthy, healthy},
+		},
+		{
+			name:   "slow probes recover to degraded",
+			probes: []string{"down", "slow", "slow", "ok", "ok"},
+			want:   []string{unhealthy, unhealthy, degraded, degraded, healthy},
+		},
+		{
+			name:   "flapping stays degraded",
+			probes: []string{"ok", "slow", "ok", "slow", "ok", "ok"},
+			want:   []string{healthy, degraded, degraded, degraded, degraded, healthy},
+		},
+		{
+			name:   "behind within the limit degrades",
+			probes: []string{"ok", "behind", "behind", "behind", "behind"},
+			want:   []string{healthy, degraded, degraded, degraded, degraded},
+		},
+		{
+			name:   "far behind fails",
+			probes: []string{"ok", "far", "far", "far"},
+			want:   []string{healthy, degraded, degraded, unhealthy},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewHealthMonitor(nil, nil, testConfig())
+			for i, name := range tt.probes {
+				status := probes[name]
+				m.applyState("test", &status)
+
+				if status.State != tt.want[i] {
+					t.Fatalf("probe %d (%s): state = %s, want %s", i, name, status.State, tt.want[i])
+				}
+				if status.Healthy != (status.State != unhealthy) {
+					t.Errorf("probe %d (%s): Healthy = %v in state %s", i, name, status.Healthy, status.State)
+				}
+			}
+		})
+	}
+}
+
+// namedProvider is a provider that only has a name
+type namedProvider struct {
+	provider.Provider
+	name string
+}
+
+func (p namedProvider) Name() string { return p.name }
+
+func TestComputeSlotLag(t *testing.T) {
+	m := NewHealthMonitor(nil, nil, testConfig())
+	providers := []provider.Provider{namedProvider{name: "a"}, namedProvider{name: "b"}, namedProvider{name: "c"}}
+
+	sample := func(a, b uint64) []*provider.HealthStatus {
+		return []*provider.HealthStatus{
+			{Slots: map[string]uint64{"processed": a, "finalized": a - 32}},
+			{Slots: map[string]uint64{"processed": b, "finalized": b - 40}},
+			nil, // probe failed
+		}
+	}
+
+	statuses := sample(1000, 990)
+	m.computeSlotLag(providers, statuses)
+	if statuses[0].SlotLag != 0 || statuses[1].SlotLag != 18 {
+		t.Errorf("slot lag = %d, %d, want 0, 18 (worst commitment)", statuses[0].SlotLag, statuses[1].SlotLag)
+	}
+	if statuses[0].Stalled || statuses[1].Stalled {
+		t.Errorf("providers stalled on their first sample")
+	}
+
+	// b stops advancing
+	time.Sleep(60 * time.Millisecond)
+	statuses = sample(1100, 990)
+	m.computeSlotLag(providers, statuses)
+	if statuses[0].Stalled || !statuses[1].Stalled {
+		t.Errorf("stalled = %v, %v, want false, true", statuses[0].Stalled, statuses[1].Stalled)
+	}
+	if statuses[1].SlotLag != 118 {
+		t.Errorf("slot lag of b = %d, want 118", statuses[1].SlotLag)
+	}
+}
@@ -0,0 +1,397 @@
+package latency
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	keyPrefix = "latency:samples:"
+
+	// keysKey is the Redis set of the sample lists written by any replica
+	keysKey = "latency:keys"
+
+	// window bounds the samples kept per provider and per provider method
+	window     = 5 * time.Minute
+	maxSamples = 256
+
+	// MinSamples is how many samples a method needs before its own stats are used
+	// instead of the provider-wide ones
+	MinSamples = 10
+
+	// ewmaAlpha is the weight of the newest sample in the EWMA
+	ewmaAlpha = 0.3
+
+	// syncInterval is how often samples are exchanged with other replicas through Redis
+	syncInterval = time.Second
+	syncTimeout  = 2 * time.Second
+)
+
+// Stats summarizes the recent upstream attempt latencies of a provider or provider method
+type Stats struct {
+	Samples int     `json:"samples"`
+	EWMA    float64 `json:"ewma_ms"`
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	P99     float64 `json:"p99_ms"`
+}
+
+// Status describes the latency of a provider for the status API
+type Status struct {
+	Stats
+	Methods map[string]Stats `json:"methods,omitempty"`
+}
+
+// key identifies a sample window; an empty method is the provider-wide window
+type key struct {
+	provider string
+	method   string
+}
+
+func (k key) redisKey() string {
+	if k.method == "" {
+		return keyPrefix + k.provider
+	}
+	return keyPrefix + k.provider + ":" + k.method
+}
+
+// member encodes a key in the keysKey set; method names never contain '|'
+func (k key) member() string {
+	return k.provider + "|" + k.method
+}
+
+func parseMember(m string) (key, bool) {
+	i := strings.LastIndexByte(m, '|')
+	if i <= 0 {
+		return key{}, false
+	}
+	return key{provider: m[:i], method: m[i+1:]}, true
+}
+
+// sample is one attempt latency in ms, taken at a unix time in ms
+type sample struct {
+	at int64
+	ms float64
+}
+
+func (s sample) encode() string {
+	return strconv.FormatInt(s.at, 10) + ":" + strconv.FormatFloat(s.ms, 'f', 2, 64)
+}
+
+func parseSample(v string) (sample, bool) {
+	at, ms, ok := strings.Cut(v, ":")
+	if !ok {
+		return sample{}, false
+	}
+	var s sample
+	var err1, err2 error
+	s.at, err1 = strconv.ParseInt(at, 10, 64)
+	s.ms, err2 = strconv.ParseFloat(ms, 64)
+	return s, err1 == nil && err2 == nil
+}
+
+// samples is a sliding window of samples, oldest first, with its stats computed lazily
+type samples struct {
+	list   []sample
+	sorted []float64 // latencies in ascending order, nil when stale
+	stats  Stats
+}
+
+func (w *samples) add(s sample) {
+	w.list = append(w.list, s)
+	if len(w.list) > maxSamples {
+		w.list = w.list[len(w.list)-maxSamples:]
+	}
+	w.sorted = nil
+}
+
+// prune drops the samples older than the window
+func (w *samples) prune(now time.Time) {
+	cutoff := now.Add(-window).UnixMilli()
+	i := 0
+	for i < len(w.list) && w.list[i].at < cutoff {
+		i++
+	}
+	if i > 0 {
+		w.list = w.list[i:]
+		w.sorted = nil
+	}
+}
+
+// compute refreshes the stats if samples changed since they were last computed
+func (w *samples) compute() {
+	if w.sorted != nil || len(w.list) == 0 {
+		return
+	}
+	w.sorted = make([]float64, len(w.list))
+	ewma := w.list[0].ms
+	for i, s := range w.list {
+		w.sorted[i] = s.ms
+		ewma = ewmaAlpha*s.ms + (1-ewmaAlpha)*ewma
+	}
+	slices.Sort(w.sorted)
+	w.stats = Stats{
+		Samples: len(w.list),
+		EWMA:    ewma,
+		P50:     w.percentile(0.50),
+		P95:     w.percentile(0.95),
+		P99:     w.percentile(0.99),
+	}
+}
+
+// percentile returns the q-th (0..1) percentile; compute must have run
+func (w *samples) percentile(q float64) float64 {
+	return w.sorted[int(q*float64(len(w.sorted)-1))]
+}
+
+// Tracker keeps a sliding window of upstream attempt latencies per provider and per
+// provider method. Samples are exchanged through Redis lists so every replica computes
+// its stats from the attempts of all replicas; without Redis only local samples are used.
+type Tracker struct {
+	redis *redis.Client
+
+	mu      sync.Mutex
+	windows map[key]*samples
+	pending []pendingSample // samples not yet written to Redis
+
+	syncing   atomic.Bool
+	synced    time.Time
+	redisDown bool
+}
+
+type pendingSample struct {
+	key key
+	sample
+}
+
+// NewTracker creates a new latency tracker
+func NewTracker(redisClient *redis.Client) *Tracker {
+	return &Tracker{
+		redis:   redisClient,
+		windows: make(map[key]*samples),
+	}
+}
+
+// Observe records the latency of one upstream attempt of method on a provider
+func (t *Tracker) Observe(provider, method string, d time.Duration) {
+	s := sample{at: time.Now().UnixMilli(), ms: float64(d) / float64(time.Millisecond)}
+
+	keys := []key{{provider: provider}}
+	if method != "" {
+		keys = append(keys, key{provider: provider, method: method})
+	}
+
+	t.mu.Lock()
+	for _, k := range keys {
+		t.window(k).add(s)
+		if t.redis != nil {
+			t.pending = append(t.pending, pendingSample{key: k, sample: s})
+		}
+	}
+	t.mu.Unlock()
+
+	t.maybeSync()
+}
+
+// Lookup returns the stats of a method on a provider, falling back to the provider-wide
+// stats until the method has MinSamples samples. It returns false if the provider has
+// no samples at all.
+func (t *Tracker) Lookup(provider, method string) (Stats, bool) {
+	t.maybeSync()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.lookup(provider, method)
+	if w == nil {
+		return Stats{}, false
+	}
+	return w.stats, true
+}
+
+// Percentile returns the q-th (0..1) percentile latency of a method on a provider, with
+// the same fallback as Lookup, or false if there are fewer than MinSamples samples
+func (t *Tracker) Percentile(provider, method string, q float64) (time.Duration, bool) {
+	t.maybeSync()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.lookup(provider, method)
+	if w == nil || len(w.list) < MinSamples {
+		return 0, false
+	}
+	return time.Duration(w.percentile(q) * float64(time.Millisecond)), true
+}
+
+// Status returns the provider-wide and per-method stats of a provider, or nil if it
+// has no samples
+func (t *Tracker) Status(provider string) *Status {
+	t.maybeSync()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.windows[key{provider: provider}]
+	if w == nil || len(w.list) == 0 {
+		return nil
+	}
+	w.compute()
+	status := &Status{Stats: w.stats, Methods: make(map[string]Stats)}
+	for k, mw := range t.windows {
+		if k.provider == provider && k.method != "" && len(mw.list) > 0 {
+			mw.compute()
+			status.Methods[k.method] = mw.stats
+		}
+	}
+	return status
+}
+
+// lookup returns the computed window used for a method on a provider. Callers must hold t.mu.
+func (t *Tracker) lookup(provider, method string) *samples {
+	if w := t.windows[key{provider: provider, method: method}]; method != "" && w != nil && len(w.list) >= MinSamples {
+		w.compute()
+		return w
+	}
+	if w := t.windows[key{provider: provider}]; w != nil && len(w.list) > 0 {
+		w.compute()
+		return w
+	}
+	return nil
+}
+
+// window returns the window of k, creating it if needed. Callers must hold t.mu.
+func (t *Tracker) window(k key) *samples {
+	w, ok := t.windows[k]
+	if !ok {
+		w = &samples{}
+		t.windows[k] = w
+	}
+	return w
+}
+
+// maybeSync starts a background sync once per syncInterval
+func (t *Tracker) maybeSync() {
+	t.mu.Lock()
+	due := time.Since(t.synced) >= syncInterval
+	t.mu.Unlock()
+	if due && t.syncing.CompareAndSwap(false, true) {
+		go t.sync()
+	}
+}
+
+// sync writes pending samples to Redis and replaces the local windows with the shared
+// ones. If Redis is unavailable the local windows are kept and only pruned.
+func (t *Tracker) sync() {
+	defer t.syncing.Store(false)
+
+	t.mu.Lock()
+	t.synced = time.Now()
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	var shared map[key]*samples
+	if t.redis != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+		var err error
+		shared, err = t.exchange(ctx, pending)
+		cancel()
+
+		t.mu.Lock()
+		if (err != nil) != t.redisDown {
+			if err != nil {
+				log.Printf("[LATENCY] Cannot share latency samples through Redis, using local samples: %v", err)
+			} else {
+				log.Printf("[LATENCY] Sharing latency samples through Redis again")
+			}
+			t.redisDown = err != nil
+		}
+		t.mu.Unlock()
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if shared != nil {
+		// Samples observed while syncing are not in Redis yet
+		for _, p := range t.pending {
+			w, ok := shared[p.key]
+			if !ok {
+				w = &samples{}
+				shared[p.key] = w
+			}
+			w.add(p.sample)
+		}
+		t.windows = shared
+	}
+	for k, w := range t.windows {
+		w.prune(now)
+		if len(w.list) == 0 {
+			delete(t.windows, k)
+		}
+	}
+}
+
+// exchange pushes samples to their Redis lists and reads back every list
+func (t *Tracker) exchange(ctx context.Context, pending []pendingSample) (map[key]*samples, error) {
+	pipe := t.redis.Pipeline()
+	touched := make(map[key]bool)
+	for _, p := range pending {
+		pipe.LPush(ctx, p.key.redisKey(), p.encode())
+		touched[p.key] = true
+	}
+	for k := range touched {
+		pipe.LTrim(ctx, k.redisKey(), 0, maxSamples-1)
+		pipe.Expire(ctx, k.redisKey(), window)
+		pipe.SAdd(ctx, keysKey, k.member())
+	}
+	if len(touched) > 0 {
+		pipe.Expire(ctx, keysKey, window)
+	}
+	members := pipe.SMembers(ctx, keysKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	var keys []key
+	pipe = t.redis.Pipeline()
+	var lists []*redis.StringSliceCmd
+	for _, m := range members.Val() {
+		k, ok := parseMember(m)
+		if !ok {
+			continue
+		}
+		keys = append(keys, k)
+		lists = append(lists, pipe.LRange(ctx, k.redisKey(), 0, maxSamples-1))
+	}
+	if len(keys) == 0 {
+		return make(map[key]*samples), nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	shared := make(map[key]*samples, len(keys))
+	for i, k := range keys {
+		var list []sample
+		for _, v := range lists[i].Val() {
+			if s, ok := parseSample(v); ok {
+				list = append(list, s)
+			}
+		}
+		if len(list) == 0 {
+			continue
+		}
+		// Lists are newest first and replicas interleave, so order by time
+		slices.SortFunc(list, func(a, b sample) int { return cmp.Compare(a.at, b.at) })
+		shared[k] = &samples{list: list}
+	}
+	return shared, nil
+}
@@ -6,13 +6,13 @@ import (
 )
 
 var (
-	// RequestsTotal tracks total RPC requests by provider, method, and status
+	// RequestsTotal tracks total RPC requests by provider, method, status, and client key
 	RequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "rpc_requests_total",
-			Help: "Total RPC requests by provider, method, and status",
+			Help: "Total RPC requests by provider, method, status, and client key",
 		},
-		[]string{"provider", "method", "status"},
+		[]string{"provider", "method", "status", "key"},
 	)
 
 	// RequestDuration tracks RPC request latency
@@ -30,17 +30,230 @@ var (
 	ProviderHealthStatus = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rpc_provider_health_status",
-			Help: "Provider health status (1=healthy, 0=unhealthy)",
+			Help: "Provider health status (1=healthy, 0.5=degraded, 0=unhealthy)",
 		},
 		[]string{"provider"},
 	)
 
-	// TotalCostUSD tracks the cumulative cost incurred per provider
+	// TotalCostUSD tracks the cumulative cost incurred per provider, method and client key
 	TotalCostUSD = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "rpc_total_cost_usd",
-			Help: "Total cost in USD by provider",
+			Help: "Total cost in USD by provider, method and client key",
+		},
+		[]string{"provider", "method", "key"},
+	)
+
+	// ActiveSubscriptions tracks upstream WebSocket subscriptions per provider
+	ActiveSubscriptions = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_ws_active_subscriptions",
+			Help: "Active upstream WebSocket subscriptions by provider",
+		},
+		[]string{"provider"},
+	)
+
+	// SubscriptionFailovers tracks subscriptions moved away from a failed provider
+	SubscriptionFailovers = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_ws_subscription_failovers_total",
+			Help: "WebSocket subscriptions moved off a failed provider",
+		},
+		[]string{"provider"},
+	)
+
+	// HedgedRequests tracks duplicate requests sent because the first provider was slow
+	HedgedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_hedged_requests_total",
+			Help: "Hedged duplicate requests by provider, method, and outcome (won/lost/failed)",
+		},
+		[]string{"provider", "method", "outcome"},
+	)
+
+	// RoutingRuleMatches tracks which routing rule handled each request
+	RoutingRuleMatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_routing_rule_matches_total",
+			Help: "Requests routed by each routing rule",
+		},
+		[]string{"rule", "method"},
+	)
+
+	// BroadcastResults tracks per-provider outcomes of transaction broadcasts
+	BroadcastResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_broadcast_results_total",
+			Help: "Transaction broadcast results by provider and result (accepted/rejected/failed/deduplicated)",
+		},
+		[]string{"provider", "result"},
+	)
+
+	// ProviderSlot tracks the latest slot reported by each provider per commitment
+	ProviderSlot = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_provider_slot",
+			Help: "Latest slot reported by provider and commitment",
+		},
+		[]string{"provider", "commitment"},
+	)
+
+	// ProviderSlotLag tracks how many slots each provider is behind the cluster tip
+	ProviderSlotLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_provider_slot_lag",
+			Help: "Slots behind the cluster tip by provider and commitment",
+		},
+		[]string{"provider", "commitment"},
+	)
+
+	// ProviderStalled flags providers whose slot has stopped advancing (1 = stalled)
+	ProviderStalled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_provider_stalled",
+			Help: "Provider slot has stopped advancing (1=stalled, 0=advancing)",
+		},
+		[]string{"provider"},
+	)
+
+	// StaleResponses tracks upstream answers rejected for being older than the client session
+	StaleResponses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_stale_responses_total",
+			Help: "Responses rejected for being behind the session's context slot, by provider and method",
+		},
+		[]string{"provider", "method"},
+	)
+
+	// CoalescedRequests tracks requests answered by another identical request's upstream call
+	CoalescedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_coalesced_requests_total",
+			Help: "Requests that shared an in-flight upstream call, by method and scope (local/remote)",
+		},
+		[]string{"method", "scope"},
+	)
+
+	// StaleServed tracks expired cache entries served to clients
+	StaleServed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_cache_stale_served_total",
+			Help: "Expired cache entries served by method and mode (stale-while-revalidate/stale-if-error)",
+		},
+		[]string{"method", "mode"},
+	)
+
+	// CacheLookups tracks cache lookups by the tier that answered them
+	CacheLookups = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_cache_lookups_total",
+			Help: "Cache lookups by method and result (l1/l2/miss)",
+		},
+		[]string{"method", "result"},
+	)
+
+	// CacheL1Entries tracks the number of entries in the in-process cache
+	CacheL1Entries = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rpc_cache_l1_entries",
+			Help: "Entries held in the in-process (L1) cache",
+		},
+	)
+
+	// CacheL1Bytes tracks the size of the values in the in-process cache
+	CacheL1Bytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rpc_cache_l1_bytes",
+			Help: "Total size in bytes of values held in the in-process (L1) cache",
+		},
+	)
+
+	// RateLimited tracks requests held back by provider rate limits
+	RateLimited = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_provider_rate_limited_total",
+			Help: "Requests rate limited by provider and source (local budget/upstream 429)",
+		},
+		[]string{"provider", "source"},
+	)
+
+	// ClientRequestsRejected tracks client requests refused by authentication, rate limits or quotas
+	ClientRequestsRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_client_requests_rejected_total",
+			Help: "Client requests rejected by key and reason",
+		},
+		[]string{"key", "reason"},
+	)
+
+	// BudgetState tracks spend budgets: 0 = within budget, 1 = past the soft threshold, 2 = exhausted
+	BudgetState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_budget_state",
+			Help: "Spend budget state by budget (provider name or global): 0=ok, 1=soft, 2=exhausted",
+		},
+		[]string{"budget"},
+	)
+
+	// TierProviders tracks how many providers of each failover tier can currently serve
+	TierProviders = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_tier_available_providers",
+			Help: "Providers able to serve by failover tier (priority or backup)",
+		},
+		[]string{"tier"},
+	)
+
+	// TierSpills tracks how often routing moved past the first tier
+	TierSpills = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_tier_spills_total",
+			Help: "Times routing spilled to a later failover tier, by the tier spilled to",
+		},
+		[]string{"tier"},
+	)
+
+	// OutlierEjections tracks providers ejected for failing or slowing down on live traffic
+	OutlierEjections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_outlier_ejections_total",
+			Help: "Outlier ejections by provider and reason (error_rate or latency)",
+		},
+		[]string{"provider", "reason"},
+	)
+
+	// ProviderEjected tracks whether a provider is currently ejected as an outlier
+	ProviderEjected = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_provider_ejected",
+			Help: "Whether a provider is ejected as an outlier (1) or not (0)",
 		},
 		[]string{"provider"},
 	)
+
+	// CircuitBreakerState tracks the circuit breaker of each provider: 0 = closed, 1 = half-open, 2 = open
+	CircuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpc_circuit_breaker_state",
+			Help: "Circuit breaker state by provider: 0=closed, 1=half-open, 2=open",
+		},
+		[]string{"provider"},
+	)
+
+	// ConfigReloads tracks configuration reloads by result (success or failure)
+	ConfigReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rpc_config_reloads_total",
+			Help: "Configuration reloads by result (success or failure)",
+		},
+		[]string{"result"},
+	)
+
+	// ConfigLastReloadSuccessful tracks whether the last configuration reload was applied
+	ConfigLastReloadSuccessful = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rpc_config_last_reload_successful",
+			Help: "Whether the last configuration reload was applied (1) or rejected (0)",
+		},
+	)
 )
@@ -0,0 +1,353 @@
+package outlier
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+)
+
+const (
+	// buckets is the number of slices the sliding window is split into
+	buckets = 10
+
+	// maxEvents bounds the ejection history kept for the status API
+	maxEvents = 100
+)
+
+// Ejection reasons
+const (
+	ReasonErrorRate = "error_rate"
+	ReasonLatency   = "latency"
+)
+
+// Event is the ejection of a provider or its return to the pool
+type Event struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Action   string    `json:"action"` // "ejected" or "restored"
+	Reason   string    `json:"reason,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+}
+
+// Ejection describes a currently ejected provider
+type Ejection struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+	Count  int       `json:"count"` // ejections in a row, each doubling the ejection time
+}
+
+// Status describes outlier detection for the status API
+type Status struct {
+	Ejected map[string]Ejection `json:"ejected"`
+	Events  []Event             `json:"events"`
+}
+
+// bucket holds the attempts of one slice of the window
+type bucket struct {
+	start      time.Time
+	total      int
+	failures   int
+	latencySum time.Duration
+	latencyN   int
+}
+
+// tracked is the recent traffic and ejection state of a provider
+type tracked struct {
+	buckets   []bucket
+	ejection  *Ejection
+	ejections int       // ejections in a row
+	restored  time.Time // end of the last ejection
+	capped    bool      // an ejection was refused by MaxEjectedPercent
+}
+
+// window summarizes the attempts of a provider still inside the window
+type window struct {
+	total     int
+	errorRate float64
+	latency   float64 // mean latency of successful attempts in ms, 0 if none
+}
+
+// Detector watches the outcome of live requests and ejects providers whose error rate
+// or latency over a sliding window is far worse than that of their peers. Ejected
+// providers come back after a backoff that doubles with every repeated ejection.
+type Detector struct {
+	config config.OutlierConfig
+
+	mu        sync.Mutex
+	providers map[string]*tracked
+	events    []Event
+}
+
+// NewDetector creates a new outlier detector for the named providers
+func NewDetector(cfg config.OutlierConfig, names []string) *Detector {
+	d := &Detector{
+		config:    cfg,
+		providers: make(map[string]*tracked, len(names)),
+	}
+	for _, name := range names {
+		d.providers[name] = &tracked{}
+	}
+	return d
+}
+
+// Reload applies new settings and forgets providers that are no longer configured
+func (d *Detector) Reload(cfg config.OutlierConfig, names []string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.config = cfg
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+		if _, ok := d.providers[name]; !ok {
+			d.providers[name] = &tracked{}
+		}
+	}
+	for name, t := range d.providers {
+		if keep[name] && cfg.Enabled {
+			continue
+		}
+		if t.ejection != nil {
+			metrics.ProviderEjected.WithLabelValues(name).Set(0)
+		}
+		if keep[name] {
+			d.providers[name] = &tracked{}
+		} else {
+			delete(d.providers, name)
+		}
+	}
+}
+
+// Record adds the outcome of one upstream attempt. A zero latency adds no latency
+// sample, e.g. for batches whose latency is not comparable to single requests.
+func (d *Detector) Record(name string, failed bool, latency time.Duration) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.config.Enabled {
+		return
+	}
+
+	t, ok := d.providers[name]
+	if !ok {
+		t = &tracked{}
+		d.providers[name] = t
+	}
+
+	now := time.Now()
+	width := d.config.Window / buckets
+	if n := len(t.buckets); n == 0 || now.Sub(t.buckets[n-1].start) >= width {
+		t.buckets = append(t.buckets, bucket{start: now})
+	}
+	b := &t.buckets[len(t.buckets)-1]
+	b.total++
+	if failed {
+		b.failures++
+	} else if latency > 0 {
+		b.latencySum += latency
+		b.latencyN++
+	}
+
+	if t.ejection == nil {
+		d.evaluate(name, t, now)
+	}
+}
+
+// Ejected reports whether a provider is currently ejected
+func (d *Detector) Ejected(name string) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.providers[name]
+	return d.config.Enabled && ok && d.ejected(name, t, time.Now())
+}
+
+// Status returns the ejected providers and the recent ejection events
+func (d *Detector) Status() *Status {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.config.Enabled {
+		return nil
+	}
+
+	now := time.Now()
+	status := &Status{Ejected: make(map[string]Ejection), Events: slices.Clone(d.events)}
+	for name, t := range d.providers {
+		if d.ejected(name, t, now) {
+			status.Ejected[name] = *t.ejection
+		}
+	}
+	return status
+}
+
+// ejected reports whether a provider is ejected, returning it to the pool once its
+// ejection time is over. Callers must hold d.mu.
+func (d *Detector) ejected(name string, t *tracked, now time.Time) bool {
+	if t.ejection == nil {
+		return false
+	}
+	if now.Before(t.ejection.Until) {
+		return true
+	}
+
+	t.ejection = nil
+	t.restored = now
+	metrics.ProviderEjected.WithLabelValues(name).Set(0)
+	log.Printf("[OUTLIER] Provider %s is back in the pool", name)
+	d.addEvent(Event{Time: now, Provider: name, Action: "restored"})
+	return false
+}
+
+// evaluate compares a provider with the median of its peers and ejects it if it is an
+// outlier. Callers must hold d.mu.
+func (d *Detector) evaluate(name string, t *tracked, now time.Time) {
+	own := d.summarize(t, now)
+	if own.total < d.config.MinRequests {
+		return
+	}
+
+	var peerErrors, peerLatencies []float64
+	ejected := 0
+	for other, pt := range d.providers {
+		if d.ejected(other, pt, now) {
+			ejected++
+			continue
+		}
+		if other == name {
+			continue
+		}
+		peer := d.summarize(pt, now)
+		if peer.total < d.config.MinRequests {
+			continue
+		}
+		peerErrors = append(peerErrors, peer.errorRate)
+		if peer.latency > 0 {
+			peerLatencies = append(peerLatencies, peer.latency)
+		}
+	}
+	if len(peerErrors) == 0 {
+		// Nothing to compare with: a pool-wide problem is not an outlier
+		return
+	}
+
+	var reason, detail string
+	minLatency := float64(d.config.MinLatency) / float64(time.Millisecond)
+	switch {
+	case own.errorRate >= d.config.MinErrorRate && own.errorRate > d.config.ErrorRateFactor*median(peerErrors):
+		reason = ReasonErrorRate
+		detail = fmt.Sprintf("error rate %.0f%% vs %.0f%% for peers", own.errorRate*100, median(peerErrors)*100)
+	case own.latency >= minLatency && len(peerLatencies) > 0 && own.latency > d.config.LatencyFactor*median(peerLatencies):
+		reason = ReasonLatency
+		detail = fmt.Sprintf("mean latency %.0fms vs %.0fms for peers", own.latency, median(peerLatencies))
+	default:
+		t.capped = false
+		return
+	}
+
+	if (ejected+1)*100 > d.config.MaxEjectedPercent*len(d.providers) {
+		if !t.capped {
+			log.Printf("[OUTLIER] Not ejecting %s (%s): %d of %d providers are already ejected", name, detail, ejected, len(d.providers))
+			t.capped = true
+		}
+		return
+	}
+	d.eject(name, t, reason, detail, now)
+}
+
+// eject takes a provider out of the pool for the backoff of its ejection count.
+// Callers must hold d.mu.
+func (d *Detector) eject(name string, t *tracked, reason, detail string, now time.Time) {
+	// Ejections only add up while the provider keeps failing soon after coming back
+	if now.Sub(t.restored) > d.config.MaxEjectionTime {
+		t.ejections = 0
+	}
+	t.ejections++
+
+	duration := d.config.BaseEjectionTime
+	for i := 1; i < t.ejections && duration < d.config.MaxEjectionTime; i++ {
+		duration *= 2
+	}
+	duration = min(duration, d.config.MaxEjectionTime)
+
+	t.ejection = &Ejection{Since: now, Until: now.Add(duration), Reason: reason, Count: t.ejections}
+	t.buckets = nil
+	t.capped = false
+
+	metrics.OutlierEjections.WithLabelValues(name, reason).Inc()
+	metrics.ProviderEjected.WithLabelValues(name).Set(1)
+	log.Printf("[OUTLIER] Ejected provider %s for %v: %s", name, duration, detail)
+	d.addEvent(Event{
+		Time:     now,
+		Provider: name,
+		Action:   "ejected",
+		Reason:   reason,
+		Detail:   detail,
+		Duration: duration.String(),
+	})
+}
+
+// summarize drops expired buckets of a provider and sums the rest. Callers must hold d.mu.
+func (d *Detector) summarize(t *tracked, now time.Time) window {
+	cutoff := now.Add(-d.config.Window)
+	i := 0
+	for i < len(t.buckets) && t.buckets[i].start.Before(cutoff) {
+		i++
+	}
+	t.buckets = t.buckets[i:]
+
+	var w window
+	var failures, latencyN int
+	var latencySum time.Duration
+	for _, b := range t.buckets {
+		w.total += b.total
+		failures += b.failures
+		latencySum += b.latencySum
+		latencyN += b.latencyN
+	}
+	if w.total > 0 {
+		w.errorRate = float64(failures) / float64(w.total)
+	}
+	if latencyN > 0 {
+		w.latency = float64(latencySum) / float64(latencyN) / float64(time.Millisecond)
+	}
+	return w
+}
+
+// addEvent appends to the bounded event history. Callers must hold d.mu.
+func (d *Detector) addEvent(e Event) {
+	d.events = append(d.events, e)
+	if len(d.events) > maxEvents {
+		d.events = d.events[len(d.events)-maxEvents:]
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
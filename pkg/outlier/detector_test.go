@@ -0,0 +1,191 @@
+package outlier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+)
+
+const baseEjection = 50 * time.Millisecond
+
+func testConfig() config.OutlierConfig {
+	return config.OutlierConfig{
+		Enabled:           true,
+		Window:            time.Minute,
+		MinRequests:       10,
+		MinErrorRate:      0.2,
+		ErrorRateFactor:   2,
+		MinLatency:        100 * time.Millisecond,
+		LatencyFactor:     3,
+		BaseEjectionTime:  baseEjection,
+		MaxEjectionTime:   4 * baseEjection,
+		MaxEjectedPercent: 50,
+	}
+}
+
+// traffic is a run of attempts recorded for one provider
+type traffic struct {
+	provider string
+	requests int
+	failures int
+	latency  time.Duration
+}
+
+// record adds the attempts of tr, failures first
+func record(d *Detector, tr traffic) {
+	for i := 0; i < tr.requests; i++ {
+		d.Record(tr.provider, i < tr.failures, tr.latency)
+	}
+}
+
+func TestDetectorEjection(t *testing.T) {
+	healthy := func(name string) traffic { return traffic{name, 10, 0, 50 * time.Millisecond} }
+
+	tests := []struct {
+		name       string
+		traffic    []traffic
+		wantReason map[string]string // ejected providers and why
+	}{
+		{
+			name:       "error rate outlier",
+			traffic:    []traffic{healthy("b"), healthy("c"), {"a", 10, 5, 50 * time.Millisecond}},
+			wantReason: map[string]string{"a": ReasonErrorRate},
+		},
+		{
+			name:    "error rate below the minimum",
+			traffic: []traffic{healthy("b"), healthy("c"), {"a", 10, 1, 50 * time.Millisecond}},
+		},
+		{
+			name: "pool-wide errors",
+			traffic: []traffic{
+				{"b", 10, 5, 50 * time.Millisecond},
+				{"c", 10, 5, 50 * time.Millisecond},
+				{"a", 10, 5, 50 * time.Millisecond},
+			},
+		},
+		{
+			name:       "latency outlier",
+			traffic:    []traffic{healthy("b"), healthy("c"), {"a", 10, 0, 400 * time.Millisecond}},
+			wantReason: map[string]string{"a": ReasonLatency},
+		},
+		{
+			name:    "latency below the minimum",
+			traffic: []traffic{{"b", 10, 0, 10 * time.Millisecond}, {"c", 10, 0, 10 * time.Millisecond}, {"a", 10, 0, 90 * time.Millisecond}},
+		},
+		{
+			name:    "too few requests",
+			traffic: []traffic{healthy("b"), healthy("c"), {"a", 9, 9, 50 * time.Millisecond}},
+		},
+		{
+			name:    "no peers to compare with",
+			traffic: []traffic{{"a", 10, 10, 50 * time.Millisecond}},
+		},
+		{
+			name:    "peers with too few requests",
+			traffic: []traffic{{"b", 5, 0, 50 * time.Millisecond}, {"a", 10, 10, 50 * time.Millisecond}},
+		},
+		{
+			name: "max ejected percent",
+			traffic: []traffic{
+				healthy("c"),
+				{"a", 10, 10, 50 * time.Millisecond},
+				{"b", 10, 10, 50 * time.Millisecond},
+			},
+			wantReason: map[string]string{"a": ReasonErrorRate},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDetector(testConfig(), []string{"a", "b", "c"})
+			for _, tr := range tt.traffic {
+				record(d, tr)
+			}
+
+			status := d.Status()
+			if len(status.Ejected) != len(tt.wantReason) {
+				t.Fatalf("ejected = %v, want %v", status.Ejected, tt.wantReason)
+			}
+			for name, reason := range tt.wantReason {
+				ejection, ok := status.Ejected[name]
+				if !ok || ejection.Reason != reason {
+					t.Errorf("ejection of %s = %+v, want reason %s", name, ejection, reason)
+				}
+				if !d.Ejected(name) {
+					t.Errorf("Ejected(%s) = false, want true", name)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectorBackoff(t *testing.T) {
+	d := NewDetector(testConfig(), []string{"a", "b"})
+	failing := traffic{"a", 10, 10, 0}
+
+	// Each ejection soon after the last one doubles, up to the maximum
+	for i, want := range []time.Duration{baseEjection, 2 * baseEjection, 4 * baseEjection, 4 * baseEjection} {
+		record(d, traffic{"b", 10, 0, 0})
+		record(d, failing)
+
+		ejection, ok := d.Status().Ejected["a"]
+		if !ok {
+			t.Fatalf("ejection %d: a was not ejected", i+1)
+		}
+		if got := ejection.Until.Sub(ejection.Since); got != want || ejection.Count != i+1 {
+			t.Fatalf("ejection %d: %v (count %d), want %v (count %d)", i+1, got, ejection.Count, want, i+1)
+		}
+
+		time.Sleep(want + 5*time.Millisecond)
+		if d.Ejected("a") {
+			t.Fatalf("ejection %d: a still ejected after %v", i+1, want)
+		}
+	}
+
+	events := d.Status().Events
+	if len(events) != 8 || events[0].Action != "ejected" || events[1].Action != "restored" {
+		t.Errorf("events = %+v, want alternating ejections and restores", events)
+	}
+}
+
+func TestDetectorDisabled(t *testing.T) {
+	cfg := testConfig()
+	cfg.Enabled = false
+	d := NewDetector(cfg, []string{"a", "b"})
+
+	record(d, traffic{"b", 10, 0, 0})
+	record(d, traffic{"a", 10, 10, 0})
+	if d.Ejected("a") {
+		t.Errorf("Ejected(a) = true with detection disabled")
+	}
+	if status := d.Status(); status != nil {
+		t.Errorf("Status() = %+v, want nil with detection disabled", status)
+	}
+}
+
+func TestDetectorReload(t *testing.T) {
+	d := NewDetector(testConfig(), []string{"a", "b", "c"})
+	record(d, traffic{"b", 10, 0, 0})
+	record(d, traffic{"a", 10, 10, 0})
+	if !d.Ejected("a") {
+		t.Fatalf("a was not ejected")
+	}
+
+	// Removed providers are forgotten, the others keep their state
+	d.Reload(testConfig(), []string{"a", "c"})
+	if !d.Ejected("a") {
+		t.Errorf("a lost its ejection on reload")
+	}
+	if _, ok := d.providers["b"]; ok {
+		t.Errorf("removed provider b is still tracked")
+	}
+
+	// Disabling detection returns every provider to the pool
+	cfg := testConfig()
+	cfg.Enabled = false
+	d.Reload(cfg, []string{"a", "c"})
+	if d.Ejected("a") {
+		t.Errorf("a still ejected after detection was disabled")
+	}
+}
@@ -4,155 +4,446 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/budget"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/health"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/latency"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/outlier"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/ratelimit"
 )
 
-// ProviderPool manages a pool of RPC providers with round-robin selection and health filtering
+// ProviderPool manages a pool of RPC providers with health filtering and pluggable
+// selection strategies: a default one, one per method group and one per routing rule
 type ProviderPool struct {
 	providers []provider.Provider
 	redis     *redis.Client
-	current   int
+	slotLag   config.SlotLagConfig
+	limiter   *ratelimit.Limiter
+	budgets   *budget.Tracker
+	latency   *latency.Tracker
+	outliers  *outlier.Detector
 	mu        sync.Mutex
+
+	weights    map[string]int
+	priorities map[string]int
+	backups    map[string]bool
+
+	// Degraded providers keep degradedWeight of the traffic they would otherwise get
+	degradedWeight float64
+
+	// Failover tiers: the tier first attempts were last served from, and occupancy
+	tiers       config.TierConfig
+	activeTier  int
+	activeSince time.Time
+	tierCounts  map[int]int
+
+	strategy        Strategy
+	strategyName    string
+	groups          []*methodGroup
+	routeStrategies map[string]Strategy
+}
+
+// methodGroup is a group of methods with its own strategy
+type methodGroup struct {
+	config.MethodGroup
+	strategy Strategy
 }
 
 // NewProviderPool creates a new provider pool
-func NewProviderPool(providers []provider.Provider, redisClient *redis.Client) *ProviderPool {
-	return &ProviderPool{
-		providers: providers,
-		redis:     redisClient,
-		current:   0,
+func NewProviderPool(providers []provider.Provider, redisClient *redis.Client, cfg *config.Config, limiter *ratelimit.Limiter, budgets *budget.Tracker) (*ProviderPool, error) {
+	names := make([]string, len(providers))
+	for i, prov := range providers {
+		names[i] = prov.Name()
+	}
+	p := &ProviderPool{
+		providers:       providers,
+		redis:           redisClient,
+		slotLag:         cfg.Health.SlotLag,
+		limiter:         limiter,
+		budgets:         budgets,
+		latency:         latency.NewTracker(redisClient),
+		outliers:        outlier.NewDetector(cfg.Health.Outlier, names),
+		weights:         make(map[string]int),
+		priorities:      make(map[string]int),
+		backups:         make(map[string]bool),
+		degradedWeight:  cfg.Health.DegradedWeight,
+		tiers:           cfg.Routing.Tiers,
+		activeSince:     time.Now(),
+		routeStrategies: make(map[string]Strategy),
+	}
+	for _, pc := range cfg.Providers {
+		p.weights[pc.Name] = pc.Weight
+		p.priorities[pc.Name] = pc.Priority
+		p.backups[pc.Name] = pc.Backup
 	}
+	p.activeTier = backupTier
+	for _, prov := range providers {
+		p.activeTier = min(p.activeTier, p.tierOf(prov.Name()))
+	}
+
+	if err := p.SetStrategy("", cfg.Routing.Strategy); err != nil {
+		return nil, err
+	}
+	for _, group := range cfg.Routing.MethodGroups {
+		strategy, err := NewStrategy(group.Strategy, p)
+		if err != nil {
+			return nil, fmt.Errorf("method group %s: %w", group.Name, err)
+		}
+		p.groups = append(p.groups, &methodGroup{MethodGroup: group, strategy: strategy})
+	}
+	return p, nil
 }
 
-// Next returns the next provider using a latency-optimized strategy
+// Next returns the provider for a request
 func (p *ProviderPool) Next(ctx context.Context) (provider.Provider, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	return p.NextWithExclude(ctx, nil)
+}
 
-	if len(p.providers) == 0 {
+// NextWithExclude returns the provider for a request, skipping the providers in exclude
+// (e.g. those already tried by earlier attempts)
+func (p *ProviderPool) NextWithExclude(ctx context.Context, exclude map[string]bool) (provider.Provider, error) {
+	if p.Size() == 0 {
 		return nil, fmt.Errorf("no providers available")
 	}
 
-	// 1. Filter healthy providers
-	var healthyProviders []provider.Provider
-	for _, prov := range p.providers {
-		status, err := health.GetProviderStatus(ctx, p.redis, prov.Name())
-		if err != nil || status == nil || status.Healthy {
-			healthyProviders = append(healthyProviders, prov)
+	route := RouteFromContext(ctx)
+	filtered, degraded := p.filter(ctx, route, exclude)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Tier occupancy is only representative for first attempts over the whole pool
+	track := len(exclude) == 0 && (route == nil || len(route.Providers) == 0)
+	candidates := p.applyTiers(filtered, track)
+	if len(candidates) == 0 {
+		if len(exclude) > 0 {
+			return nil, fmt.Errorf("no un-tried healthy providers available")
 		}
+		return nil, fmt.Errorf("no healthy providers available")
 	}
 
-	// 2. Prioritize discovery: find providers without latency data
-	// Use round-robin to ensure we discover ALL providers, not just the first one
-	for i := 0; i < len(healthyProviders); i++ {
-		idx := (p.current + i) % len(healthyProviders)
-		prov := healthyProviders[idx]
-		_, err := p.GetLatency(ctx, prov.Name())
-		if err != nil {
-			log.Printf("[ROUTING] Discovery: Selected healthy provider without latency data: %s", prov.Name())
-			p.current = (idx + 1) % len(healthyProviders)
-			return prov, nil
+	return p.strategyFor(ctx, route).Select(ctx, p.weighDegraded(candidates, degraded)), nil
+}
+
+// weighDegraded drops each degraded candidate with probability 1-degradedWeight, as long
+// as a healthy candidate is left to take its traffic. Callers must hold p.mu.
+func (p *ProviderPool) weighDegraded(candidates []provider.Provider, degraded map[string]bool) []provider.Provider {
+	var healthy, kept []provider.Provider
+	for _, prov := range candidates {
+		if !degraded[prov.Name()] {
+			healthy = append(healthy, prov)
+			kept = append(kept, prov)
+		} else if rand.Float64() < p.degradedWeight {
+			kept = append(kept, prov)
 		}
 	}
+	if len(healthy) == 0 {
+		return candidates
+	}
+	return kept
+}
 
-	// 3. Find provider with lowest latency
-	var bestProv provider.Provider
-	minLatency := int64(999999)
+// strategyFor returns the strategy of the request's routing rule, else of its method
+// group, else the pool default. Callers must hold p.mu.
+func (p *ProviderPool) strategyFor(ctx context.Context, route *Route) Strategy {
+	if route != nil && route.Strategy != "" {
+		if strategy, ok := p.routeStrategies[route.Name]; ok {
+			return strategy
+		}
+		strategy, err := NewStrategy(route.Strategy, p)
+		if err == nil {
+			p.routeStrategies[route.Name] = strategy
+			return strategy
+		}
+		log.Printf("[ROUTING] Route %s: %v, using the pool strategy", route.Name, err)
+	}
 
-	for _, prov := range healthyProviders {
-		latency, _ := p.GetLatency(ctx, prov.Name())
-		if latency > 0 && latency < minLatency {
-			minLatency = latency
-			bestProv = prov
+	if method := MethodFromContext(ctx); method != "" {
+		for _, group := range p.groups {
+			if config.MatchMethod(group.Methods, method) {
+				return group.strategy
+			}
 		}
 	}
+	return p.strategy
+}
 
-	// 4. Select provider
-	if bestProv != nil {
-		log.Printf("[ROUTING] Selected least-latency provider: %s (%dms)", bestProv.Name(), minLatency)
-		return bestProv, nil
+// SetStrategy switches the strategy of a method group, or the pool default for group ""
+func (p *ProviderPool) SetStrategy(group, name string) error {
+	strategy, err := NewStrategy(name, p)
+	if err != nil {
+		return err
+	}
+	if name == "ordered" {
+		return fmt.Errorf("strategy %q only applies to routing rules", name)
 	}
 
-	// 4. Fallback to round-robin if no latency data (should rarely hit here now)
-	selected := healthyProviders[p.current%len(healthyProviders)]
-	p.current = (p.current + 1) % len(healthyProviders)
-	log.Printf("[ROUTING] Selected healthy provider (round-robin): %s", selected.Name())
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	return selected, nil
+	if group == "" {
+		p.strategy, p.strategyName = strategy, name
+		log.Printf("[ROUTING] Pool strategy set to %s", name)
+		return nil
+	}
+	for _, g := range p.groups {
+		if g.Name == group {
+			g.strategy, g.Strategy = strategy, name
+			log.Printf("[ROUTING] Strategy of method group %s set to %s", group, name)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown method group %q", group)
 }
 
-func (p *ProviderPool) NextWithExclude(ctx context.Context, exclude map[string]bool) (provider.Provider, error) {
+// Strategies returns the strategy names of the pool default ("") and each method group
+func (p *ProviderPool) Strategies() map[string]string {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if len(p.providers) == 0 {
-		return nil, fmt.Errorf("no providers available")
+	names := map[string]string{"": p.strategyName}
+	for _, g := range p.groups {
+		names[g.Name] = g.Strategy
 	}
+	return names
+}
+
+// Healthy returns every healthy provider allowed by the route in ctx
+func (p *ProviderPool) Healthy(ctx context.Context) []provider.Provider {
+	filtered, _ := p.filter(ctx, RouteFromContext(ctx), nil)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.applyTiers(filtered, false)
+}
 
-	// 1. Filter healthy providers
-	var candidateProviders []provider.Provider
-	for _, prov := range p.providers {
+// filter returns the healthy, non-excluded providers allowed by the route, in route
+// order when the route lists providers, after applying spend budgets, along with the
+// degraded ones among them. Providers with rate limit budget left are preferred;
+// saturated ones are returned only when no others are left. The filters read shared
+// state from Redis, so they run on a snapshot of the providers without holding p.mu;
+// callers then keep the first failover tiers that can serve.
+func (p *ProviderPool) filter(ctx context.Context, route *Route, exclude map[string]bool) ([]provider.Provider, map[string]bool) {
+	p.mu.Lock()
+	providers, slotLag := p.providers, p.slotLag
+	p.mu.Unlock()
+
+	candidates, degraded := p.healthyCandidates(ctx, providers, slotLag, route, exclude)
+	healthy := p.withinBudget(ctx, candidates)
+
+	var withBudget []provider.Provider
+	for _, prov := range healthy {
+		// Every provider can be cooling down after a 429, which is known locally; only
+		// providers with a plan limit need a Redis round trip for their buckets
+		if p.limiter.CoolingDown(prov.Name()) {
+			continue
+		}
+		if !p.limiter.Limited(prov.Name()) || p.limiter.HasBudget(ctx, prov.Name()) {
+			withBudget = append(withBudget, prov)
+		}
+	}
+	if len(withBudget) == 0 {
+		withBudget = healthy
+	}
+	return withBudget, degraded
+}
+
+// healthyCandidates applies the route, exclusions, health and slot lag filters.
+// Degraded providers are kept and returned for weighDegraded.
+func (p *ProviderPool) healthyCandidates(ctx context.Context, providers []provider.Provider, slotLag config.SlotLagConfig, route *Route, exclude map[string]bool) ([]provider.Provider, map[string]bool) {
+	ordered := providers
+	if route != nil && len(route.Providers) > 0 {
+		byName := make(map[string]provider.Provider, len(providers))
+		for _, prov := range providers {
+			byName[prov.Name()] = prov
+		}
+		ordered = make([]provider.Provider, 0, len(route.Providers))
+		for _, name := range route.Providers {
+			if prov, ok := byName[name]; ok {
+				ordered = append(ordered, prov)
+			}
+		}
+	}
+
+	// Providers that are behind the cluster tip or ejected as outliers are only used
+	// when nothing better is left
+	var result, demoted, lagging, ejected []provider.Provider
+	degraded := make(map[string]bool)
+	for _, prov := range ordered {
 		if exclude[prov.Name()] {
 			continue
 		}
+		if p.outliers.Ejected(prov.Name()) {
+			ejected = append(ejected, prov)
+			continue
+		}
 		status, err := health.GetProviderStatus(ctx, p.redis, prov.Name())
-		if err != nil || status == nil || status.Healthy {
-			candidateProviders = append(candidateProviders, prov)
+		degraded[prov.Name()] = err == nil && status != nil && status.State == provider.Degraded
+		if err != nil || status == nil {
+			result = append(result, prov)
+			continue
+		}
+		if !status.Healthy {
+			continue
+		}
+
+		switch {
+		case !slotLag.Enabled:
+			result = append(result, prov)
+		case status.Stalled || status.SlotLag > slotLag.MaxLag:
+			lagging = append(lagging, prov)
+		case status.SlotLag > slotLag.DemoteLag:
+			demoted = append(demoted, prov)
+		default:
+			result = append(result, prov)
 		}
 	}
 
-	if len(candidateProviders) == 0 {
-		return nil, fmt.Errorf("no un-tried healthy providers available")
+	if len(result) > 0 {
+		return result, degraded
+	}
+	if len(demoted) > 0 {
+		return demoted, degraded
+	}
+	if len(lagging) > 0 {
+		log.Printf("[ROUTING] All candidate providers are stalled or lagging, using them anyway")
+		return lagging, degraded
+	}
+	if len(ejected) > 0 {
+		log.Printf("[ROUTING] All candidate providers are ejected as outliers, using them anyway")
+	}
+	return ejected, degraded
+}
+
+// withinBudget applies spend budgets. Providers that exhausted their budget are dropped
+// unless no others are left; providers past their soft threshold are dropped when a
+// provider that is cheaper for the request's method is available, which shifts their
+// traffic to cheaper providers.
+func (p *ProviderPool) withinBudget(ctx context.Context, provs []provider.Provider) []provider.Provider {
+	if !p.budgets.Enabled() || len(provs) == 0 {
+		return provs
 	}
 
-	// 2. Discovery
-	for i := 0; i < len(candidateProviders); i++ {
-		idx := (p.current + i) % len(candidateProviders)
-		prov := candidateProviders[idx]
-		_, err := p.GetLatency(ctx, prov.Name())
-		if err != nil {
-			p.current = (idx + 1) % len(candidateProviders)
-			return prov, nil
+	states := make(map[string]budget.State, len(provs))
+	var available []provider.Provider
+	for _, prov := range provs {
+		states[prov.Name()] = p.budgets.State(ctx, prov.Name())
+		if states[prov.Name()] != budget.Exhausted {
+			available = append(available, prov)
 		}
 	}
 
-	// 3. Least Latency
-	var bestProv provider.Provider
-	minLatency := int64(999999)
-	for _, prov := range candidateProviders {
-		latency, _ := p.GetLatency(ctx, prov.Name())
-		if latency > 0 && latency < minLatency {
-			minLatency = latency
-			bestProv = prov
+	// With every budget exhausted, keep serving from the cheapest providers
+	lastResort := len(available) == 0
+	if lastResort {
+		log.Printf("[BUDGET] All candidate providers are over budget, using the cheapest as a last resort")
+		available = provs
+	}
+
+	method := MethodFromContext(ctx)
+	costs := make(map[string]float64, len(available))
+	cheapest := p.Cost(available[0], method)
+	for _, prov := range available {
+		costs[prov.Name()] = p.Cost(prov, method)
+		cheapest = min(cheapest, costs[prov.Name()])
+	}
+
+	result := make([]provider.Provider, 0, len(available))
+	for _, prov := range available {
+		if (!lastResort && states[prov.Name()] == budget.OK) || costs[prov.Name()] <= cheapest {
+			result = append(result, prov)
 		}
 	}
+	return result
+}
+
+// Update is a validated provider list and routing settings, built by PrepareUpdate
+// and swapped in by ApplyUpdate
+type Update struct {
+	providers    []provider.Provider
+	cfg          *config.Config
+	strategy     Strategy
+	strategyName string
+	groups       []*methodGroup
+}
 
-	if bestProv != nil {
-		return bestProv, nil
+// PrepareUpdate builds the strategies for a new provider list and the routing settings
+// of cfg without changing the pool. Strategies whose name did not change keep their
+// state; routing rule strategies are rebuilt on next use.
+func (p *ProviderPool) PrepareUpdate(providers []provider.Provider, cfg *config.Config) (*Update, error) {
+	if cfg.Routing.Strategy == "ordered" {
+		return nil, fmt.Errorf("strategy %q only applies to routing rules", cfg.Routing.Strategy)
 	}
 
-	// 4. Round-robin
-	selected := candidateProviders[p.current%len(candidateProviders)]
-	p.current = (p.current + 1) % len(candidateProviders)
-	return selected, nil
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := &Update{providers: providers, cfg: cfg, strategy: p.strategy, strategyName: cfg.Routing.Strategy}
+	if cfg.Routing.Strategy != p.strategyName {
+		var err error
+		if u.strategy, err = NewStrategy(cfg.Routing.Strategy, p); err != nil {
+			return nil, err
+		}
+	}
+	current := make(map[string]*methodGroup, len(p.groups))
+	for _, g := range p.groups {
+		current[g.Name] = g
+	}
+	for _, group := range cfg.Routing.MethodGroups {
+		if g, ok := current[group.Name]; ok && g.Strategy == group.Strategy {
+			u.groups = append(u.groups, &methodGroup{MethodGroup: group, strategy: g.strategy})
+			continue
+		}
+		strategy, err := NewStrategy(group.Strategy, p)
+		if err != nil {
+			return nil, fmt.Errorf("method group %s: %w", group.Name, err)
+		}
+		u.groups = append(u.groups, &methodGroup{MethodGroup: group, strategy: strategy})
+	}
+	return u, nil
 }
-func (p *ProviderPool) GetLatency(ctx context.Context, name string) (int64, error) {
-	if p.redis == nil {
-		return 0, fmt.Errorf("redis not initialized")
+
+// ApplyUpdate swaps in a prepared provider list and routing settings at once
+func (p *ProviderPool) ApplyUpdate(u *Update) {
+	cfg := u.cfg
+	names := make([]string, len(u.providers))
+	for i, prov := range u.providers {
+		names[i] = prov.Name()
 	}
-	key := fmt.Sprintf("latency:%s", name)
-	val, err := p.redis.Get(ctx, key).Result()
-	if err != nil {
-		return 0, err
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.providers = u.providers
+	p.weights = make(map[string]int, len(cfg.Providers))
+	p.priorities = make(map[string]int, len(cfg.Providers))
+	p.backups = make(map[string]bool, len(cfg.Providers))
+	for _, pc := range cfg.Providers {
+		p.weights[pc.Name] = pc.Weight
+		p.priorities[pc.Name] = pc.Priority
+		p.backups[pc.Name] = pc.Backup
 	}
-	var latency int64
-	fmt.Sscanf(val, "%d", &latency)
-	return latency, nil
+	p.degradedWeight = cfg.Health.DegradedWeight
+	p.slotLag = cfg.Health.SlotLag
+	p.tiers = cfg.Routing.Tiers
+	p.strategy, p.strategyName = u.strategy, u.strategyName
+	p.groups = u.groups
+	p.routeStrategies = make(map[string]Strategy)
+	p.outliers.Reload(cfg.Health.Outlier, names)
+}
+
+// Contains reports whether prov is one of the providers currently in the pool
+func (p *ProviderPool) Contains(prov provider.Provider) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Contains(p.providers, prov)
 }
 
 // GetAll returns all providers in the pool
@@ -169,17 +460,55 @@ func (p *ProviderPool) Size() int {
 	return len(p.providers)
 }
 
-// ForwardRequest forwards a request using the next available provider
-// UpdateLatency stores the latest latency of a provider in Redis
-func (p *ProviderPool) UpdateLatency(ctx context.Context, name string, latency time.Duration) {
-	if p.redis == nil {
-		return
+// ObserveLatency records the latency of one upstream attempt of method on a provider
+func (p *ProviderPool) ObserveLatency(name, method string, d time.Duration) {
+	p.latency.Observe(name, method, d)
+}
+
+// ObserveOutcome feeds the result of one upstream attempt to outlier detection. A zero
+// latency adds no latency sample.
+func (p *ProviderPool) ObserveOutcome(name string, failed bool, latency time.Duration) {
+	p.outliers.Record(name, failed, latency)
+}
+
+// OutlierStatus returns the providers ejected as outliers and recent ejections, or nil
+// if outlier detection is disabled
+func (p *ProviderPool) OutlierStatus() *outlier.Status {
+	return p.outliers.Status()
+}
+
+// Latency returns the latency stats used to route method to a provider: the method's
+// own once it has enough samples, else the provider-wide ones. It returns false if the
+// provider has not been measured yet.
+func (p *ProviderPool) Latency(name, method string) (latency.Stats, bool) {
+	return p.latency.Lookup(name, method)
+}
+
+// LatencyPercentile returns the q-th (0..1) percentile latency of method on a provider,
+// or false if there are not enough samples yet
+func (p *ProviderPool) LatencyPercentile(name, method string, q float64) (time.Duration, bool) {
+	return p.latency.Percentile(name, method, q)
+}
+
+// LatencyStatus returns the latency stats of a provider for the status API
+func (p *ProviderPool) LatencyStatus(name string) *latency.Status {
+	return p.latency.Status(name)
+}
+
+// weight returns the weighted round-robin weight of a provider
+func (p *ProviderPool) weight(name string) int {
+	if w, ok := p.weights[name]; ok && w > 0 {
+		return w
 	}
-	key := fmt.Sprintf("latency:%s", name)
-	// Store latency in milliseconds as a string for easy retrieval
-	p.redis.Set(ctx, key, fmt.Sprintf("%d", latency.Milliseconds()), 10*time.Minute)
+	return 1
+}
+
+// priority returns the priority of a provider (lower is preferred)
+func (p *ProviderPool) priority(name string) int {
+	return p.priorities[name]
 }
 
+// ForwardRequest forwards a request using the next available provider
 func (p *ProviderPool) ForwardRequest(ctx context.Context, req *provider.RPCRequest) (*provider.RPCResponse, string, error) {
 	// Get next provider
 	prov, err := p.Next(ctx)
@@ -196,6 +525,21 @@ func (p *ProviderPool) ForwardRequest(ctx context.Context, req *provider.RPCRequ
 	return resp, prov.Name(), nil
 }
 
+// Cost returns the price in USD of one request for method sent to prov
+func (p *ProviderPool) Cost(prov provider.Provider, method string) float64 {
+	return p.budgets.Cost(prov, method)
+}
+
+// RecordSpend adds the cost of a request sent to the named provider to its spend budgets
+func (p *ProviderPool) RecordSpend(ctx context.Context, name string, usd float64) {
+	p.budgets.Record(ctx, name, usd)
+}
+
+// BudgetStatus returns the spend budget of the named provider, or the global budget for ""
+func (p *ProviderPool) BudgetStatus(ctx context.Context, name string) *budget.Status {
+	return p.budgets.Status(ctx, name)
+}
+
 // GetRedis returns the redis client used by the pool
 func (p *ProviderPool) GetRedis() *redis.Client {
 	return p.redis
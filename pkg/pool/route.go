@@ -0,0 +1,43 @@
+package pool
+
+import "context"
+
+// Route restricts provider selection for a request to a subset of providers
+// and an optional strategy. It is produced by the router's routing rules.
+type Route struct {
+	// Name of the routing rule that matched
+	Name string
+	// Providers allowed for the request, in preference order (empty = all)
+	Providers []string
+	// Strategy is one of config.RuleStrategies; "" uses the pool default
+	Strategy string
+}
+
+type routeKey struct{}
+
+// WithRoute returns a context carrying the route for provider selection
+func WithRoute(ctx context.Context, route *Route) context.Context {
+	if route == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route stored in ctx, or nil
+func RouteFromContext(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey{}).(*Route)
+	return route
+}
+
+type methodKey struct{}
+
+// WithMethod returns a context carrying the RPC method for method group strategies
+func WithMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, methodKey{}, method)
+}
+
+// MethodFromContext returns the RPC method stored in ctx, or ""
+func MethodFromContext(ctx context.Context) string {
+	method, _ := ctx.Value(methodKey{}).(string)
+	return method
+}
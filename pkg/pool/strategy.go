@@ -0,0 +1,174 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// Strategy selects the provider for a request among the healthy candidates.
+// Select is called with the pool lock held, and candidates is never empty.
+type Strategy interface {
+	Select(ctx context.Context, candidates []provider.Provider) provider.Provider
+}
+
+// NewStrategy creates a built-in strategy by name (see config.RuleStrategies)
+func NewStrategy(name string, p *ProviderPool) (Strategy, error) {
+	switch name {
+	case "ordered":
+		return ordered{}, nil
+	case "round-robin":
+		return &roundRobin{}, nil
+	case "weighted-round-robin":
+		return &weightedRoundRobin{pool: p, current: make(map[string]int)}, nil
+	case "priority":
+		return &priority{pool: p}, nil
+	case "least-latency":
+		return &leastLatency{pool: p}, nil
+	case "p2c":
+		return &powerOfTwoChoices{pool: p}, nil
+	case "cheapest":
+		return &cheapest{pool: p}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q", name)
+}
+
+// ordered picks the first candidate, i.e. the first healthy provider in rule order
+type ordered struct{}
+
+func (ordered) Select(_ context.Context, candidates []provider.Provider) provider.Provider {
+	return candidates[0]
+}
+
+// roundRobin rotates through the candidates
+type roundRobin struct {
+	next int
+}
+
+func (s *roundRobin) Select(_ context.Context, candidates []provider.Provider) provider.Provider {
+	selected := candidates[s.next%len(candidates)]
+	s.next = (s.next + 1) % len(candidates)
+	return selected
+}
+
+// weightedRoundRobin spreads requests in proportion to provider weights, interleaving
+// providers smoothly instead of sending a provider's whole share in a row
+type weightedRoundRobin struct {
+	pool    *ProviderPool
+	current map[string]int
+}
+
+func (s *weightedRoundRobin) Select(_ context.Context, candidates []provider.Provider) provider.Provider {
+	total := 0
+	var best provider.Provider
+	for _, prov := range candidates {
+		weight := s.pool.weight(prov.Name())
+		total += weight
+		s.current[prov.Name()] += weight
+		if best == nil || s.current[prov.Name()] > s.current[best.Name()] {
+			best = prov
+		}
+	}
+	s.current[best.Name()] -= total
+	return best
+}
+
+// priority uses the providers with the lowest priority value, rotating among equals
+type priority struct {
+	pool *ProviderPool
+	rr   roundRobin
+}
+
+func (s *priority) Select(ctx context.Context, candidates []provider.Provider) provider.Provider {
+	var top []provider.Provider
+	for _, prov := range candidates {
+		switch {
+		case len(top) == 0 || s.pool.priority(prov.Name()) < s.pool.priority(top[0].Name()):
+			top = []provider.Provider{prov}
+		case s.pool.priority(prov.Name()) == s.pool.priority(top[0].Name()):
+			top = append(top, prov)
+		}
+	}
+	return s.rr.Select(ctx, top)
+}
+
+// leastLatency first tries providers without latency data so every provider is
+// measured, then picks the lowest EWMA latency for the request's method
+type leastLatency struct {
+	pool *ProviderPool
+	rr   roundRobin
+}
+
+func (s *leastLatency) Select(ctx context.Context, candidates []provider.Provider) provider.Provider {
+	// Discovery: rotate through providers without latency data
+	for i := 0; i < len(candidates); i++ {
+		idx := (s.rr.next + i) % len(candidates)
+		prov := candidates[idx]
+		if _, ok := s.pool.Latency(prov.Name(), ""); !ok {
+			log.Printf("[ROUTING] Discovery: Selected healthy provider without latency data: %s", prov.Name())
+			s.rr.next = (idx + 1) % len(candidates)
+			return prov
+		}
+	}
+
+	method := MethodFromContext(ctx)
+	var best provider.Provider
+	minLatency := 0.0
+	for _, prov := range candidates {
+		stats, _ := s.pool.Latency(prov.Name(), method)
+		if best == nil || stats.EWMA < minLatency {
+			best, minLatency = prov, stats.EWMA
+		}
+	}
+	return best
+}
+
+// powerOfTwoChoices compares two random candidates and picks the one with the lower
+// EWMA latency for the request's method. Unmeasured providers win so that they get measured.
+type powerOfTwoChoices struct {
+	pool *ProviderPool
+}
+
+func (s *powerOfTwoChoices) Select(ctx context.Context, candidates []provider.Provider) provider.Provider {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	method := MethodFromContext(ctx)
+	statsA, _ := s.pool.Latency(a.Name(), method)
+	statsB, _ := s.pool.Latency(b.Name(), method)
+	if statsB.EWMA < statsA.EWMA {
+		return b
+	}
+	return a
+}
+
+// cheapest uses the providers with the lowest cost for the request's method, rotating among equals
+type cheapest struct {
+	pool *ProviderPool
+	rr   roundRobin
+}
+
+func (s *cheapest) Select(ctx context.Context, candidates []provider.Provider) provider.Provider {
+	method := MethodFromContext(ctx)
+	var top []provider.Provider
+	minCost := 0.0
+	for _, prov := range candidates {
+		cost := s.pool.Cost(prov, method)
+		switch {
+		case len(top) == 0 || cost < minCost:
+			top, minCost = []provider.Provider{prov}, cost
+		case cost == minCost:
+			top = append(top, prov)
+		}
+	}
+	return s.rr.Select(ctx, top)
+}
@@ -0,0 +1,135 @@
+package pool
+
+import (
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// backupTier sorts backup providers after every priority tier
+const backupTier = math.MaxInt
+
+// TierStatus describes the failover tiers for the status API
+type TierStatus struct {
+	Active    string              `json:"active"` // last tier first attempts needed
+	Since     time.Time           `json:"since"`
+	Available map[string]int      `json:"available"` // providers able to serve, by tier
+	Providers map[string][]string `json:"providers"`
+}
+
+// tierOf returns the failover tier of a provider. Without tiering all providers except
+// the backup ones share a single tier.
+func (p *ProviderPool) tierOf(name string) int {
+	switch {
+	case p.backups[name]:
+		return backupTier
+	case !p.tiers.Enabled:
+		return 1
+	}
+	return p.priorities[name]
+}
+
+func tierLabel(tier int) string {
+	if tier == backupTier {
+		return "backup"
+	}
+	return strconv.Itoa(tier)
+}
+
+// applyTiers keeps the candidates of the first tiers that together have at least
+// MinProviders providers, and backup providers only when no other candidate is left.
+// With track set the tier occupancy and spills are recorded. Callers must hold p.mu.
+func (p *ProviderPool) applyTiers(candidates []provider.Provider, track bool) []provider.Provider {
+	if len(candidates) == 0 {
+		return candidates
+	}
+
+	counts := make(map[int]int)
+	for _, prov := range candidates {
+		counts[p.tierOf(prov.Name())]++
+	}
+	tiers := make([]int, 0, len(counts))
+	for tier := range counts {
+		tiers = append(tiers, tier)
+	}
+	slices.Sort(tiers)
+
+	minProviders := 1
+	if p.tiers.Enabled {
+		minProviders = p.tiers.MinProviders
+	}
+	last, n := tiers[0], 0
+	for _, tier := range tiers {
+		if tier == backupTier && n > 0 {
+			break
+		}
+		last = tier
+		n += counts[tier]
+		if n >= minProviders {
+			break
+		}
+	}
+
+	result := make([]provider.Provider, 0, n)
+	for _, prov := range candidates {
+		if p.tierOf(prov.Name()) <= last {
+			result = append(result, prov)
+		}
+	}
+
+	if track {
+		p.trackTiers(counts, last)
+	}
+	return result
+}
+
+// trackTiers records tier occupancy and the tier requests are served from, counting a
+// spill each time the active tier moves further out. Callers must hold p.mu.
+func (p *ProviderPool) trackTiers(counts map[int]int, last int) {
+	first := backupTier
+	for _, prov := range p.providers {
+		tier := p.tierOf(prov.Name())
+		first = min(first, tier)
+		metrics.TierProviders.WithLabelValues(tierLabel(tier)).Set(float64(counts[tier]))
+	}
+	p.tierCounts = counts
+
+	if last == p.activeTier {
+		return
+	}
+	if last > p.activeTier {
+		if last > first {
+			metrics.TierSpills.WithLabelValues(tierLabel(last)).Inc()
+		}
+		log.Printf("[TIER] Spilling from tier %s to tier %s", tierLabel(p.activeTier), tierLabel(last))
+	} else {
+		log.Printf("[TIER] Back on tier %s (from tier %s)", tierLabel(last), tierLabel(p.activeTier))
+	}
+	p.activeTier = last
+	p.activeSince = time.Now()
+}
+
+// TierStatus returns the failover tiers, their providers and the tier in use
+func (p *ProviderPool) TierStatus() TierStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := TierStatus{
+		Active:    tierLabel(p.activeTier),
+		Since:     p.activeSince,
+		Available: make(map[string]int),
+		Providers: make(map[string][]string),
+	}
+	for _, prov := range p.providers {
+		tier := p.tierOf(prov.Name())
+		label := tierLabel(tier)
+		status.Providers[label] = append(status.Providers[label], prov.Name())
+		status.Available[label] = p.tierCounts[tier]
+	}
+	return status
+}
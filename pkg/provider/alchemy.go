@@ -10,9 +10,9 @@ type AlchemyProvider struct {
 }
 
 // NewAlchemyProvider creates a new Alchemy provider
-func NewAlchemyProvider(url string, costPerRequest float64) Provider {
+func NewAlchemyProvider(url, wsURL string, costPerRequest float64, maxBatchSize int) Provider {
 	return &AlchemyProvider{
-		BaseProvider: NewBaseProvider("alchemy", url, costPerRequest),
+		BaseProvider: NewBaseProvider("alchemy", url, wsURL, costPerRequest, maxBatchSize),
 	}
 }
 
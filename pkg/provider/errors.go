@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitError is returned when a provider rejects a request with HTTP 429
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration // 0 if the provider did not say
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("provider %s rate limited the request (retry after %v)", e.Provider, e.RetryAfter)
+	}
+	return fmt.Sprintf("provider %s rate limited the request", e.Provider)
+}
+
+// HTTPError is returned when a provider answers with an unexpected HTTP status. The
+// request reached the provider, so unlike a network failure it may have been billed.
+type HTTPError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("provider returned HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// retryAfter reads how long to wait from Retry-After (seconds or an HTTP date) or from
+// the common rate-limit reset headers (seconds, or a Unix timestamp)
+func retryAfter(h http.Header) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t)
+		}
+	}
+
+	for _, name := range []string{"RateLimit-Reset", "X-RateLimit-Reset"} {
+		v, err := strconv.ParseInt(h.Get(name), 10, 64)
+		if err != nil || v <= 0 {
+			continue
+		}
+		// Large values are absolute Unix timestamps rather than a number of seconds
+		if v > 1_000_000_000 {
+			return time.Until(time.Unix(v, 0))
+		}
+		return time.Duration(v) * time.Second
+	}
+
+	return 0
+}
@@ -10,9 +10,9 @@ type HeliusProvider struct {
 }
 
 // NewHeliusProvider creates a new Helius provider
-func NewHeliusProvider(url string, costPerRequest float64) Provider {
+func NewHeliusProvider(url, wsURL string, costPerRequest float64, maxBatchSize int) Provider {
 	return &HeliusProvider{
-		BaseProvider: NewBaseProvider("helius", url, costPerRequest),
+		BaseProvider: NewBaseProvider("helius", url, wsURL, costPerRequest, maxBatchSize),
 	}
 }
 
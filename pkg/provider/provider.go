@@ -7,23 +7,35 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// RPCRequest represents a JSON-RPC request
+// RPCRequest represents a JSON-RPC request.
+// ID and Params are kept as raw JSON so ids of any type round-trip exactly and
+// params may be positional (array) or by-name (object). A nil ID marks a notification.
 type RPCRequest struct {
-	JSONRPC string        `json:"jsonrpc"`
-	ID      interface{}   `json:"id"`
-	Method  string        `json:"method"`
-	Params  []interface{} `json:"params,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
 }
 
-// RPCResponse represents a JSON-RPC response
+// RPCResponse represents a JSON-RPC response.
+// Responses parsed from a provider keep the upstream bytes and are written back
+// unchanged except for the id (see response.go).
 type RPCResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	ID      interface{} `json:"id"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   *RPCError   `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+
+	// ContextSlot is result.context.slot for responses that carry one (0 otherwise)
+	ContextSlot uint64 `json:"-"`
+
+	raw   []byte // upstream response bytes
+	idPos span   // position of the id value in raw
 }
 
 // RPCError represents a JSON-RPC error
@@ -33,13 +45,30 @@ type RPCError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Health states of a provider
+const (
+	Healthy   = "healthy"
+	Degraded  = "degraded" // usable, but receives less traffic
+	Unhealthy = "unhealthy"
+)
+
 // HealthStatus represents the health state of a provider
 type HealthStatus struct {
-	Healthy      bool      `json:"healthy"`
+	Healthy      bool      `json:"healthy"` // false only when unhealthy
+	State        string    `json:"state"`
 	LastCheck    time.Time `json:"last_check"`
 	LatencyMs    int64     `json:"latency_ms"`
 	SuccessRate  float64   `json:"success_rate"`
 	ErrorMessage string    `json:"error_message,omitempty"`
+	SlotsBehind  uint64    `json:"slots_behind,omitempty"` // reported by getHealth when the node is behind
+
+	// Filled in by the health monitor
+	ConsecutiveFailures int `json:"consecutive_failures"`
+
+	// Slot tracking, filled in by the health monitor
+	Slots   map[string]uint64 `json:"slots,omitempty"` // by commitment
+	SlotLag uint64            `json:"slot_lag"`        // slots behind the cluster tip (worst commitment)
+	Stalled bool              `json:"stalled"`         // slot has stopped advancing
 }
 
 // Provider interface defines the contract for RPC providers
@@ -50,30 +79,55 @@ type Provider interface {
 	// URL returns the provider's RPC endpoint URL
 	URL() string
 	
+	// WSURL returns the provider's WebSocket endpoint URL
+	WSURL() string
+	
 	// CostPerRequest returns the cost in USD for each request
 	CostPerRequest() float64
 	
+	// MaxBatchSize returns the largest batch the provider accepts (0 = unlimited)
+	MaxBatchSize() int
+	
 	// ForwardRequest forwards an RPC request to the provider
 	ForwardRequest(ctx context.Context, req *RPCRequest) (*RPCResponse, error)
 	
+	// ForwardBatch forwards a JSON-RPC batch to the provider in a single HTTP call
+	ForwardBatch(ctx context.Context, reqs []*RPCRequest) ([]*RPCResponse, error)
+	
 	// CheckHealth performs a health check on the provider
 	CheckHealth(ctx context.Context) (*HealthStatus, error)
+
+	// GetSlot returns the provider's current slot at the given commitment
+	GetSlot(ctx context.Context, commitment string) (uint64, error)
+
+	// Drain waits for in-flight requests to finish and releases idle connections, once
+	// the provider has been replaced or removed
+	Drain(ctx context.Context) error
 }
 
 // BaseProvider implements common functionality for all providers
 type BaseProvider struct {
 	name           string
 	url            string
+	wsURL          string
 	costPerRequest float64
+	maxBatchSize   int
 	client         *http.Client
+	inflight       atomic.Int64
 }
 
-// NewBaseProvider creates a new base provider
-func NewBaseProvider(name, url string, costPerRequest float64) *BaseProvider {
+// NewBaseProvider creates a new base provider.
+// If wsURL is empty the WebSocket endpoint is derived from url.
+func NewBaseProvider(name, url, wsURL string, costPerRequest float64, maxBatchSize int) *BaseProvider {
+	if wsURL == "" {
+		wsURL = deriveWSURL(url)
+	}
 	return &BaseProvider{
 		name:           name,
 		url:            url,
+		wsURL:          wsURL,
 		costPerRequest: costPerRequest,
+		maxBatchSize:   maxBatchSize,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -90,11 +144,32 @@ func (p *BaseProvider) URL() string {
 	return p.url
 }
 
+// WSURL returns the provider WebSocket URL
+func (p *BaseProvider) WSURL() string {
+	return p.wsURL
+}
+
+// deriveWSURL maps an http(s) endpoint to the matching ws(s) endpoint
+func deriveWSURL(url string) string {
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	case strings.HasPrefix(url, "http://"):
+		return "ws://" + strings.TrimPrefix(url, "http://")
+	}
+	return url
+}
+
 // CostPerRequest returns the cost per request
 func (p *BaseProvider) CostPerRequest() float64 {
 	return p.costPerRequest
 }
 
+// MaxBatchSize returns the maximum number of requests per upstream batch
+func (p *BaseProvider) MaxBatchSize() int {
+	return p.maxBatchSize
+}
+
 // ForwardRequest forwards an RPC request to the provider
 func (p *BaseProvider) ForwardRequest(ctx context.Context, req *RPCRequest) (*RPCResponse, error) {
 	// Marshal request to JSON
@@ -103,6 +178,60 @@ func (p *BaseProvider) ForwardRequest(ctx context.Context, req *RPCRequest) (*RP
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	respBody, err := p.post(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse only the fields we need and keep the raw bytes for pass-through
+	rpcResp, err := ParseResponse(respBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return rpcResp, nil
+}
+
+// ForwardBatch forwards a batch of RPC requests to the provider
+func (p *BaseProvider) ForwardBatch(ctx context.Context, reqs []*RPCRequest) ([]*RPCResponse, error) {
+	// Marshal batch to a JSON array
+	reqBody, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	respBody, err := p.post(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// Providers that reject a batch outright answer with a single error object
+	elems, err := arrayElements(respBody, 0)
+	if err != nil {
+		if single, perr := ParseResponse(respBody); perr == nil && single.Error != nil {
+			return nil, fmt.Errorf("provider rejected batch: %s", single.Error.Message)
+		}
+		return nil, fmt.Errorf("failed to unmarshal batch response: %w", err)
+	}
+
+	// Each response keeps a slice of the shared body for pass-through
+	rpcResps := make([]*RPCResponse, 0, len(elems))
+	for _, e := range elems {
+		resp, err := ParseResponse(respBody[e.start:e.end])
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal batch response: %w", err)
+		}
+		rpcResps = append(rpcResps, resp)
+	}
+
+	return rpcResps, nil
+}
+
+// post sends a JSON body to the provider and returns the raw response body
+func (p *BaseProvider) post(ctx context.Context, reqBody []byte) ([]byte, error) {
+	p.inflight.Add(1)
+	defer p.inflight.Add(-1)
+
 	// Create HTTP request
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(reqBody))
 	if err != nil {
@@ -112,10 +241,7 @@ func (p *BaseProvider) ForwardRequest(ctx context.Context, req *RPCRequest) (*RP
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	// Send request
-	start := time.Now()
 	httpResp, err := p.client.Do(httpReq)
-	latency := time.Since(start)
-
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -128,20 +254,30 @@ func (p *BaseProvider) ForwardRequest(ctx context.Context, req *RPCRequest) (*RP
 	}
 
 	// Check HTTP status
+	if httpResp.StatusCode == http.StatusTooManyRequests {
+		return nil, &RateLimitError{Provider: p.name, RetryAfter: retryAfter(httpResp.Header)}
+	}
 	if httpResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("provider returned HTTP %d: %s", httpResp.StatusCode, string(respBody))
+		return nil, &HTTPError{Provider: p.name, StatusCode: httpResp.StatusCode, Body: string(respBody)}
 	}
 
-	// Unmarshal response
-	var rpcResp RPCResponse
-	if err := json.Unmarshal(respBody, &rpcResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
+	return respBody, nil
+}
 
-	// Log latency (can be removed or replaced with proper logger)
-	_ = latency // Placeholder for metrics
+// Drain waits until no request is in flight, or ctx is done, then closes idle connections
+func (p *BaseProvider) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	defer p.client.CloseIdleConnections()
 
-	return &rpcResp, nil
+	for p.inflight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("provider %s: %d requests still in flight: %w", p.name, p.inflight.Load(), ctx.Err())
+		}
+	}
+	return nil
 }
 
 // CheckHealth performs a basic health check by calling getHealth
@@ -151,7 +287,7 @@ func (p *BaseProvider) CheckHealth(ctx context.Context) (*HealthStatus, error) {
 	// Create health check request
 	healthReq := &RPCRequest{
 		JSONRPC: "2.0",
-		ID:      1,
+		ID:      json.RawMessage("1"),
 		Method:  "getHealth",
 	}
 
@@ -174,9 +310,52 @@ func (p *BaseProvider) CheckHealth(ctx context.Context) (*HealthStatus, error) {
 	if resp.Error != nil {
 		status.Healthy = false
 		status.ErrorMessage = resp.Error.Message
+		status.SlotsBehind = slotsBehind(resp.Error)
 		return status, nil
 	}
 
 	status.Healthy = true
 	return status, nil
 }
+
+// drainPollInterval is how often Drain checks for in-flight requests
+const drainPollInterval = 50 * time.Millisecond
+
+// errNodeUnhealthy is the getHealth error code of a node that is unhealthy or behind
+const errNodeUnhealthy = -32005
+
+// slotsBehind returns how far behind a getHealth error says the node is (0 if it does not say)
+func slotsBehind(rpcErr *RPCError) uint64 {
+	if rpcErr.Code != errNodeUnhealthy {
+		return 0
+	}
+	data, ok := rpcErr.Data.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	n, _ := data["numSlotsBehind"].(float64)
+	return uint64(max(n, 0))
+}
+
+// GetSlot calls getSlot at the given commitment
+func (p *BaseProvider) GetSlot(ctx context.Context, commitment string) (uint64, error) {
+	params, _ := json.Marshal([]map[string]string{{"commitment": commitment}})
+	resp, err := p.ForwardRequest(ctx, &RPCRequest{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage("1"),
+		Method:  "getSlot",
+		Params:  params,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if resp.Error != nil {
+		return 0, fmt.Errorf("getSlot failed: %s", resp.Error.Message)
+	}
+
+	var slot uint64
+	if err := json.Unmarshal(resp.Result, &slot); err != nil {
+		return 0, fmt.Errorf("invalid getSlot result: %w", err)
+	}
+	return slot, nil
+}
@@ -10,9 +10,9 @@ type QuickNodeProvider struct {
 }
 
 // NewQuickNodeProvider creates a new QuickNode provider
-func NewQuickNodeProvider(url string, costPerRequest float64) Provider {
+func NewQuickNodeProvider(url, wsURL string, costPerRequest float64, maxBatchSize int) Provider {
 	return &QuickNodeProvider{
-		BaseProvider: NewBaseProvider("quicknode", url, costPerRequest),
+		BaseProvider: NewBaseProvider("quicknode", url, wsURL, costPerRequest, maxBatchSize),
 	}
 }
 
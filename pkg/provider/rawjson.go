@@ -0,0 +1,202 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// The helpers in this file locate values inside a JSON document without decoding it,
+// so upstream responses can be inspected and passed through byte for byte.
+
+// span marks the byte range [start, end) of a JSON value
+type span struct {
+	start, end int
+}
+
+func (s span) valid() bool {
+	return s.end > s.start
+}
+
+// skipSpace returns the index of the first non-whitespace byte at or after i
+func skipSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipString returns the index just past the JSON string starting at data[i]
+func skipString(data []byte, i int) (int, error) {
+	for j := i + 1; j < len(data); j++ {
+		switch data[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string at offset %d", i)
+}
+
+// skipValue returns the index just past the JSON value starting at data[i]
+func skipValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, fmt.Errorf("unexpected end of JSON")
+	}
+
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(data); j++ {
+			switch data[j] {
+			case '"':
+				end, err := skipString(data, j)
+				if err != nil {
+					return 0, err
+				}
+				j = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("unterminated value at offset %d", i)
+	default:
+		j := i
+		for j < len(data) {
+			switch data[j] {
+			case ',', '}', ']', ' ', '\t', '\r', '\n':
+				return j, nil
+			}
+			j++
+		}
+		return j, nil
+	}
+}
+
+// objectFields returns the value spans of the requested top-level keys of the object
+// starting at data[start]. Keys are compared on their raw (unescaped) bytes.
+func objectFields(data []byte, start int, keys ...string) (map[string]span, error) {
+	fields := make(map[string]span, len(keys))
+
+	i := skipSpace(data, start)
+	if i >= len(data) || data[i] != '{' {
+		return nil, fmt.Errorf("expected JSON object")
+	}
+	i = skipSpace(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		return fields, nil
+	}
+
+	for i < len(data) {
+		if data[i] != '"' {
+			return nil, fmt.Errorf("expected object key at offset %d", i)
+		}
+		keyEnd, err := skipString(data, i)
+		if err != nil {
+			return nil, err
+		}
+		key := data[i+1 : keyEnd-1]
+
+		i = skipSpace(data, keyEnd)
+		if i >= len(data) || data[i] != ':' {
+			return nil, fmt.Errorf("expected ':' at offset %d", i)
+		}
+		valStart := skipSpace(data, i+1)
+		valEnd, err := skipValue(data, valStart)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			if bytes.Equal(key, []byte(k)) {
+				fields[k] = span{valStart, valEnd}
+			}
+		}
+
+		i = skipSpace(data, valEnd)
+		if i >= len(data) {
+			break
+		}
+		if data[i] == '}' {
+			return fields, nil
+		}
+		if data[i] != ',' {
+			return nil, fmt.Errorf("expected ',' or '}' at offset %d", i)
+		}
+		i = skipSpace(data, i+1)
+	}
+
+	return nil, fmt.Errorf("unterminated object")
+}
+
+// arrayElements returns the spans of the elements of the JSON array starting at data[start]
+func arrayElements(data []byte, start int) ([]span, error) {
+	var elems []span
+
+	i := skipSpace(data, start)
+	if i >= len(data) || data[i] != '[' {
+		return nil, fmt.Errorf("expected JSON array")
+	}
+	i = skipSpace(data, i+1)
+	if i < len(data) && data[i] == ']' {
+		return elems, nil
+	}
+
+	for i < len(data) {
+		end, err := skipValue(data, i)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, span{i, end})
+
+		i = skipSpace(data, end)
+		if i >= len(data) {
+			break
+		}
+		if data[i] == ']' {
+			return elems, nil
+		}
+		if data[i] != ',' {
+			return nil, fmt.Errorf("expected ',' or ']' at offset %d", i)
+		}
+		i = skipSpace(data, i+1)
+	}
+
+	return nil, fmt.Errorf("unterminated array")
+}
+
+// contextSlot extracts result.context.slot from a Solana RpcResponse-shaped result,
+// returning 0 if the result has no context
+func contextSlot(result []byte) uint64 {
+	if len(result) == 0 || result[0] != '{' {
+		return 0
+	}
+	fields, err := objectFields(result, 0, "context")
+	if err != nil || !fields["context"].valid() {
+		return 0
+	}
+	ctx := fields["context"]
+	slotFields, err := objectFields(result[:ctx.end], ctx.start, "slot")
+	if err != nil || !slotFields["slot"].valid() {
+		return 0
+	}
+	s := slotFields["slot"]
+	slot, err := strconv.ParseUint(string(result[s.start:s.end]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return slot
+}
@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// IsNotification reports whether the request has no id and therefore expects no response
+func (r *RPCRequest) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// Validate checks the request against the JSON-RPC 2.0 request object rules
+func (r *RPCRequest) Validate() error {
+	if r.JSONRPC != "2.0" {
+		return fmt.Errorf("jsonrpc must be 2.0")
+	}
+
+	if r.Method == "" {
+		return fmt.Errorf("method is required")
+	}
+
+	if len(r.ID) > 0 && !ValidID(r.ID) {
+		return fmt.Errorf("id must be a string, number or null")
+	}
+
+	if len(r.Params) > 0 {
+		switch bytes.TrimSpace(r.Params)[0] {
+		case '[', '{':
+		default:
+			return fmt.Errorf("params must be an array or an object")
+		}
+	}
+
+	return nil
+}
+
+// ValidID reports whether a raw id is a string, a number or null
+func ValidID(id []byte) bool {
+	id = bytes.TrimSpace(id)
+	if len(id) == 0 {
+		return false
+	}
+	switch c := id[0]; {
+	case c == '"', c == '-', c >= '0' && c <= '9':
+		return true
+	}
+	return bytes.Equal(id, []byte("null"))
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ParseResponse parses an upstream JSON-RPC response without decoding its result.
+// Only id, error and result.context.slot are read; the response keeps a reference
+// to data so it can be written back byte for byte. data must not be modified afterwards.
+func ParseResponse(data []byte) (*RPCResponse, error) {
+	fields, err := objectFields(data, 0, "jsonrpc", "id", "result", "error")
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &RPCResponse{
+		JSONRPC: "2.0",
+		raw:     data,
+		idPos:   fields["id"],
+	}
+
+	if s := fields["id"]; s.valid() {
+		resp.ID = json.RawMessage(data[s.start:s.end])
+	}
+
+	if s := fields["error"]; s.valid() && string(data[s.start:s.end]) != "null" {
+		resp.Error = &RPCError{}
+		if err := json.Unmarshal(data[s.start:s.end], resp.Error); err != nil {
+			return nil, fmt.Errorf("invalid error object: %w", err)
+		}
+	}
+
+	if s := fields["result"]; s.valid() {
+		resp.Result = json.RawMessage(data[s.start:s.end])
+		resp.ContextSlot = contextSlot(resp.Result)
+	}
+
+	return resp, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler using the pass-through parser
+func (r *RPCResponse) UnmarshalJSON(data []byte) error {
+	// data is only valid for the duration of the call
+	owned := make([]byte, len(data))
+	copy(owned, data)
+
+	parsed, err := ParseResponse(owned)
+	if err != nil {
+		return err
+	}
+	*r = *parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. Upstream responses are returned as received
+// with the current ID spliced in; locally built responses are encoded normally.
+func (r *RPCResponse) MarshalJSON() ([]byte, error) {
+	if r.raw == nil || !r.idPos.valid() {
+		type plain RPCResponse
+		return json.Marshal((*plain)(r))
+	}
+
+	id := r.idBytes()
+	out := make([]byte, 0, len(r.raw)-(r.idPos.end-r.idPos.start)+len(id))
+	out = append(out, r.raw[:r.idPos.start]...)
+	out = append(out, id...)
+	out = append(out, r.raw[r.idPos.end:]...)
+	return out, nil
+}
+
+// WriteTo writes the response to w without building an intermediate buffer
+func (r *RPCResponse) WriteTo(w io.Writer) (int64, error) {
+	if r.raw == nil || !r.idPos.valid() {
+		data, err := r.MarshalJSON()
+		if err != nil {
+			return 0, err
+		}
+		n, err := w.Write(data)
+		return int64(n), err
+	}
+
+	id := r.idBytes()
+	var total int64
+	for _, part := range [][]byte{r.raw[:r.idPos.start], id, r.raw[r.idPos.end:]} {
+		n, err := w.Write(part)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// idBytes returns the encoded id, using null for a missing id
+func (r *RPCResponse) idBytes() []byte {
+	if len(r.ID) == 0 {
+		return []byte("null")
+	}
+	return r.ID
+}
@@ -0,0 +1,154 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantID    string
+		wantError int // 0 for no error object
+		wantSlot  uint64
+		wantErr   bool
+	}{
+		{
+			name:   "result",
+			data:   `{"jsonrpc":"2.0","id":1,"result":42}`,
+			wantID: `1`,
+		},
+		{
+			name:     "context slot",
+			data:     `{"jsonrpc":"2.0","id":"a","result":{"context":{"apiVersion":"1.18","slot":250},"value":7}}`,
+			wantID:   `"a"`,
+			wantSlot: 250,
+		},
+		{
+			name:      "error object",
+			data:      `{"jsonrpc":"2.0","id":3,"error":{"code":-32005,"message":"Node is behind"}}`,
+			wantID:    `3`,
+			wantError: -32005,
+		},
+		{
+			name:   "null error",
+			data:   `{"jsonrpc":"2.0","id":4,"result":true,"error":null}`,
+			wantID: `4`,
+		},
+		{
+			name: "missing id",
+			data: `{"jsonrpc":"2.0","result":1}`,
+		},
+		{
+			name:    "not an object",
+			data:    `[1,2]`,
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			data:    `{"jsonrpc":"2.0","id":1,"result":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ParseResponse([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseResponse(%s) succeeded, want an error", tt.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseResponse(%s): %v", tt.data, err)
+			}
+			if string(resp.ID) != tt.wantID {
+				t.Errorf("ID = %s, want %s", resp.ID, tt.wantID)
+			}
+			if resp.ContextSlot != tt.wantSlot {
+				t.Errorf("ContextSlot = %d, want %d", resp.ContextSlot, tt.wantSlot)
+			}
+			switch {
+			case tt.wantError == 0 && resp.Error != nil:
+				t.Errorf("Error = %+v, want none", resp.Error)
+			case tt.wantError != 0 && (resp.Error == nil || resp.Error.Code != tt.wantError):
+				t.Errorf("Error = %+v, want code %d", resp.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestResponseIDSplicing(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		id       json.RawMessage
+		want     string
+	}{
+		{
+			name:     "number replaced by string",
+			upstream: `{"jsonrpc":"2.0","id":0,"result":{"value":1}}`,
+			id:       json.RawMessage(`"client-7"`),
+			want:     `{"jsonrpc":"2.0","id":"client-7","result":{"value":1}}`,
+		},
+		{
+			name:     "whitespace kept",
+			upstream: `{ "jsonrpc": "2.0", "id": 12, "result": [1, 2] }`,
+			id:       json.RawMessage(`99`),
+			want:     `{ "jsonrpc": "2.0", "id": 99, "result": [1, 2] }`,
+		},
+		{
+			name:     "id after result",
+			upstream: `{"jsonrpc":"2.0","result":"ok","id":5}`,
+			id:       json.RawMessage(`"x"`),
+			want:     `{"jsonrpc":"2.0","result":"ok","id":"x"}`,
+		},
+		{
+			name:     "cleared id is null",
+			upstream: `{"jsonrpc":"2.0","id":5,"result":null}`,
+			want:     `{"jsonrpc":"2.0","id":null,"result":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ParseResponse([]byte(tt.upstream))
+			if err != nil {
+				t.Fatalf("ParseResponse: %v", err)
+			}
+			resp.ID = tt.id
+
+			var buf bytes.Buffer
+			if _, err := resp.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteTo = %s, want %s", buf.String(), tt.want)
+			}
+
+			marshaled, err := resp.MarshalJSON()
+			if err != nil {
+				t.Fatalf("MarshalJSON: %v", err)
+			}
+			if string(marshaled) != tt.want {
+				t.Errorf("MarshalJSON = %s, want %s", marshaled, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalResponseEncoding(t *testing.T) {
+	resp := &RPCResponse{JSONRPC: "2.0", ID: json.RawMessage(`1`), Error: &RPCError{Code: -32600, Message: "Invalid Request"}}
+
+	var buf bytes.Buffer
+	if _, err := resp.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	want := `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"Invalid Request"}}`
+	if buf.String() != want {
+		t.Errorf("WriteTo = %s, want %s", buf.String(), want)
+	}
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// takeScript takes tokens from a single bucket holding at most one second of budget.
+// It returns 0 when the tokens were taken, otherwise the milliseconds until they can be.
+// KEYS: bucket
+// ARGV: now (ms), rate, cost, bucket TTL (ms)
+var takeScript = redis.NewScript(`
+local now, rate, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or rate
+local ts = tonumber(b[2]) or now
+tokens = math.min(rate, tokens + math.max(0, now - ts) * rate / 1000)
+
+if tokens < cost and tokens < rate then
+	return math.ceil((math.min(cost, rate) - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens - cost, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 0
+`)
+
+// quotaScript adds usage to a daily and a monthly counter unless either would exceed its
+// limit. It returns 0 on success, or the index (1 or 2) of the exhausted counter.
+// KEYS: daily counter, monthly counter
+// ARGV: cost, daily limit, monthly limit, daily TTL (ms), monthly TTL (ms); a limit of 0 is unlimited
+var quotaScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+for i = 1, 2 do
+	local limit = tonumber(ARGV[i + 1])
+	if limit > 0 and tonumber(redis.call('GET', KEYS[i]) or '0') + cost > limit then
+		return i
+	end
+end
+for i = 1, 2 do
+	if tonumber(ARGV[i + 1]) > 0 then
+		if redis.call('INCRBY', KEYS[i], cost) == cost then
+			redis.call('PEXPIRE', KEYS[i], ARGV[i + 3])
+		end
+	end
+end
+return 0
+`)
+
+// ClientLimiter enforces client request rates and usage quotas shared through Redis,
+// so every replica applies the same limits. If Redis is unavailable requests are allowed.
+type ClientLimiter struct {
+	redis *redis.Client
+}
+
+// NewClientLimiter creates a new client limiter
+func NewClientLimiter(redisClient *redis.Client) *ClientLimiter {
+	return &ClientLimiter{redis: redisClient}
+}
+
+// Take takes cost requests from the named bucket refilled at rate per second. It returns
+// 0 if they were taken, otherwise how long the client should wait before retrying.
+func (l *ClientLimiter) Take(ctx context.Context, bucket string, rate, cost float64) time.Duration {
+	if l == nil || rate <= 0 {
+		return 0
+	}
+	wait, err := takeScript.Run(ctx, l.redis, []string{keyPrefix + "client:" + bucket},
+		time.Now().UnixMilli(), rate, cost, bucketTTL.Milliseconds()).Int64()
+	if err != nil {
+		return 0
+	}
+	return time.Duration(wait) * time.Millisecond
+}
+
+// UseQuota counts cost requests against a client's daily and monthly quotas (0 = unlimited).
+// Periods are calendar days and months in UTC. It returns 0 if the requests fit, otherwise
+// the time until the exhausted quota resets.
+func (l *ClientLimiter) UseQuota(ctx context.Context, name string, cost, daily, monthly int64) time.Duration {
+	if l == nil || (daily <= 0 && monthly <= 0) {
+		return 0
+	}
+
+	now := time.Now().UTC()
+	dayEnd := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	monthEnd := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	keys := []string{
+		keyPrefix + "quota:" + name + ":daily:" + now.Format("2006-01-02"),
+		keyPrefix + "quota:" + name + ":monthly:" + now.Format("2006-01"),
+	}
+
+	// Counters outlive their period slightly so a late write cannot restart them
+	exhausted, err := quotaScript.Run(ctx, l.redis, keys, cost, daily, monthly,
+		(dayEnd.Sub(now) + time.Hour).Milliseconds(),
+		(monthEnd.Sub(now) + time.Hour).Milliseconds(),
+	).Int()
+	if err != nil {
+		return 0
+	}
+
+	switch exhausted {
+	case 1:
+		return dayEnd.Sub(now)
+	case 2:
+		return monthEnd.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,220 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+)
+
+const (
+	keyPrefix = "ratelimit:"
+	bucketTTL = time.Minute
+
+	// defaultCooldown applies when a provider rate limits us without saying for how long
+	defaultCooldown = time.Second
+)
+
+// bucketScript checks and optionally takes tokens from a provider's request and credit
+// buckets in one step, so replicas sharing Redis share one budget.
+// KEYS: requests bucket, credits bucket, cooldown flag
+// ARGV: now (ms), request rate, request cost, credit rate, credit cost, consume (0/1), bucket TTL (ms)
+// A bucket holds at most one second of budget; a rate of 0 disables it. A full bucket
+// always admits the request, so costs larger than the bucket go into debt instead of
+// never passing.
+var bucketScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	return 0
+end
+
+local now = tonumber(ARGV[1])
+local function level(key, rate)
+	if rate <= 0 then
+		return nil
+	end
+	local b = redis.call('HMGET', key, 'tokens', 'ts')
+	local tokens = tonumber(b[1]) or rate
+	local ts = tonumber(b[2]) or now
+	return math.min(rate, tokens + (now - ts) * rate / 1000)
+end
+
+local reqRate, reqCost = tonumber(ARGV[2]), tonumber(ARGV[3])
+local crRate, crCost = tonumber(ARGV[4]), tonumber(ARGV[5])
+local reqs = level(KEYS[1], reqRate)
+local credits = level(KEYS[2], crRate)
+
+if reqs and reqs < reqCost and reqs < reqRate then
+	return 0
+end
+if credits and credits < crCost and credits < crRate then
+	return 0
+end
+
+if ARGV[6] == '1' then
+	if reqs then
+		redis.call('HSET', KEYS[1], 'tokens', reqs - reqCost, 'ts', now)
+		redis.call('PEXPIRE', KEYS[1], ARGV[7])
+	end
+	if credits then
+		redis.call('HSET', KEYS[2], 'tokens', credits - crCost, 'ts', now)
+		redis.call('PEXPIRE', KEYS[2], ARGV[7])
+	end
+end
+return 1
+`)
+
+// Limiter enforces per-provider plan limits with token buckets shared through Redis:
+// requests per second and method-weighted credits per second. Providers that answer
+// with HTTP 429 are cooled down for the time they ask for. Cooldowns are also kept
+// locally, so this replica sees its own without asking Redis.
+type Limiter struct {
+	redis  *redis.Client
+	mu     sync.RWMutex
+	limits map[string]config.RateLimitConfig
+
+	coolMu  sync.Mutex
+	cooling map[string]time.Time // provider -> end of its local cooldown
+}
+
+// NewLimiter creates a limiter for the providers that configure a rate limit
+func NewLimiter(redisClient *redis.Client, providers []config.ProviderConfig) *Limiter {
+	l := &Limiter{redis: redisClient, cooling: make(map[string]time.Time)}
+	l.Reload(providers)
+	return l
+}
+
+// Reload replaces the plan limits. Buckets in Redis are kept, so a provider whose
+// limits did not change continues where it was.
+func (l *Limiter) Reload(providers []config.ProviderConfig) {
+	limits := make(map[string]config.RateLimitConfig)
+	for _, p := range providers {
+		if p.RateLimit.RequestsPerSecond > 0 || p.RateLimit.CreditsPerSecond > 0 {
+			limits[p.Name] = p.RateLimit
+		}
+	}
+
+	l.mu.Lock()
+	l.limits = limits
+	l.mu.Unlock()
+}
+
+// limit returns the plan limits of a provider, if it has any
+func (l *Limiter) limit(name string) (config.RateLimitConfig, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	limit, ok := l.limits[name]
+	return limit, ok
+}
+
+// Allow takes budget for sending requests (with the given total credits) to a provider.
+// It returns false while the provider is cooling down or its buckets are empty.
+// If Redis is unavailable requests are allowed.
+func (l *Limiter) Allow(ctx context.Context, name string, requests int, credits float64) bool {
+	return l.check(ctx, name, float64(requests), credits, true)
+}
+
+// Limited reports whether a provider has a plan limit
+func (l *Limiter) Limited(name string) bool {
+	if l == nil {
+		return false
+	}
+	_, ok := l.limit(name)
+	return ok
+}
+
+// HasBudget reports whether a provider could take one more request right now
+func (l *Limiter) HasBudget(ctx context.Context, name string) bool {
+	return l.check(ctx, name, 1, 1, false)
+}
+
+// Credits returns the credit cost of a method on a provider
+func (l *Limiter) Credits(name, method string) float64 {
+	if l == nil {
+		return 1
+	}
+	limit, ok := l.limit(name)
+	if !ok {
+		return 1
+	}
+	if credits, ok := config.MethodValue(limit.MethodCredits, method); ok {
+		return credits
+	}
+	return limit.DefaultCredits
+}
+
+// Cooldown stops traffic to a provider for d, e.g. after it answered with HTTP 429
+func (l *Limiter) Cooldown(ctx context.Context, name string, d time.Duration) {
+	if l == nil {
+		return
+	}
+	if d <= 0 {
+		d = defaultCooldown
+	}
+
+	until := time.Now().Add(d)
+	l.coolMu.Lock()
+	if until.After(l.cooling[name]) {
+		l.cooling[name] = until
+	}
+	l.coolMu.Unlock()
+
+	if l.redis == nil {
+		return
+	}
+	if err := l.redis.Set(ctx, keyPrefix+"cooldown:"+name, 1, d).Err(); err != nil {
+		log.Printf("[RATELIMIT] Failed to record cooldown for %s: %v", name, err)
+	}
+}
+
+// CoolingDown reports whether this replica cooled a provider down and the cooldown has
+// not ended yet. It does not ask Redis, so cooldowns set by other replicas are missed.
+func (l *Limiter) CoolingDown(name string) bool {
+	if l == nil {
+		return false
+	}
+	l.coolMu.Lock()
+	defer l.coolMu.Unlock()
+	until, ok := l.cooling[name]
+	if ok && !time.Now().Before(until) {
+		delete(l.cooling, name)
+		return false
+	}
+	return ok
+}
+
+func (l *Limiter) check(ctx context.Context, name string, requests, credits float64, consume bool) bool {
+	if l == nil {
+		return true
+	}
+	if l.CoolingDown(name) {
+		return false
+	}
+	if l.redis == nil {
+		return true
+	}
+	limit, ok := l.limit(name)
+	if !ok {
+		// Unlimited providers can still be cooled down by a 429
+		n, err := l.redis.Exists(ctx, keyPrefix+"cooldown:"+name).Result()
+		return err != nil || n == 0
+	}
+
+	flag := "0"
+	if consume {
+		flag = "1"
+	}
+	keys := []string{keyPrefix + "requests:" + name, keyPrefix + "credits:" + name, keyPrefix + "cooldown:" + name}
+	allowed, err := bucketScript.Run(ctx, l.redis, keys,
+		time.Now().UnixMilli(),
+		limit.RequestsPerSecond, requests,
+		limit.CreditsPerSecond, credits,
+		flag, bucketTTL.Milliseconds(),
+	).Int()
+	if err != nil {
+		return true
+	}
+	return allowed == 1
+}
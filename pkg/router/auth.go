@@ -0,0 +1,333 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/ratelimit"
+)
+
+const (
+	apiKeyPrefix = "apikey:"
+
+	// anonymousClient is the client name of requests without an API key
+	anonymousClient = "anonymous"
+
+	// apiKeyCacheTTL is how long keys read from Redis (or found missing there) are remembered
+	apiKeyCacheTTL = 30 * time.Second
+	maxCachedKeys  = 10000
+
+	// errRateLimitExceeded tells a client it is throttled. It is in the server error range
+	// but distinct from -32005, which Solana nodes use for "node is unhealthy/behind".
+	errRateLimitExceeded = -32029
+	errUnauthorized      = -32000
+)
+
+// cachedKey is an API key read from Redis; a nil key means it does not exist
+type cachedKey struct {
+	key     *config.ClientKeyConfig
+	expires time.Time
+}
+
+// Authenticator identifies clients by API key and enforces their request rates and
+// quotas, along with a per-IP rate limit. Limits are kept in Redis so that all replicas
+// share them. The client name travels with the request context for metrics.
+type Authenticator struct {
+	redis   *redis.Client
+	limiter *ratelimit.ClientLimiter
+
+	configMu sync.RWMutex // guards config and keys, swapped by Reload
+	config   config.AuthConfig
+	keys     map[string]config.ClientKeyConfig
+
+	mu     sync.Mutex
+	cached map[string]cachedKey
+}
+
+// NewAuthenticator creates a new API key authenticator
+func NewAuthenticator(redisClient *redis.Client, cfg config.AuthConfig) *Authenticator {
+	a := &Authenticator{
+		redis:   redisClient,
+		limiter: ratelimit.NewClientLimiter(redisClient),
+		cached:  make(map[string]cachedKey),
+	}
+	a.Reload(cfg)
+	return a
+}
+
+// Reload swaps in new auth settings and configured keys, e.g. after a key was rotated.
+// Requests already past the middleware keep the client they were authenticated as.
+func (a *Authenticator) Reload(cfg config.AuthConfig) {
+	keys := make(map[string]config.ClientKeyConfig, len(cfg.Keys))
+	for _, k := range cfg.Keys {
+		keys[k.Key] = k
+	}
+
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	a.config, a.keys = cfg, keys
+}
+
+// settings returns the current auth settings and configured keys
+func (a *Authenticator) settings() (config.AuthConfig, map[string]config.ClientKeyConfig) {
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	return a.config, a.keys
+}
+
+// Middleware authenticates and rate limits requests before they reach the RPC handlers.
+// Batches count as one request per element.
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			c.Next()
+			return
+		}
+		cfg, keys := a.settings()
+		if !cfg.Enabled {
+			c.Next()
+			return
+		}
+
+		secret := c.Param("apiKey")
+		if secret == "" {
+			secret = c.GetHeader(cfg.Header)
+		}
+
+		client := anonymousClient
+		var limits config.ClientKeyConfig
+		if secret != "" {
+			key, ok := a.lookup(c.Request.Context(), keys, secret)
+			if !ok {
+				metrics.ClientRequestsRejected.WithLabelValues(anonymousClient, "invalid_key").Inc()
+				a.reject(c, http.StatusUnauthorized, errUnauthorized, "Unauthorized: invalid API key", 0)
+				return
+			}
+			client, limits = key.Name, *key
+		} else if cfg.Required {
+			metrics.ClientRequestsRejected.WithLabelValues(anonymousClient, "missing_key").Inc()
+			a.reject(c, http.StatusUnauthorized, errUnauthorized, "Unauthorized: API key required", 0)
+			return
+		}
+
+		ctx := c.Request.Context()
+		limited := clientLimits{client: client, ip: c.ClientIP(), limits: limits}
+		if reason, message, wait := a.take(ctx, cfg, limited, requestCount(c)); wait > 0 {
+			metrics.ClientRequestsRejected.WithLabelValues(client, reason).Inc()
+			a.reject(c, http.StatusTooManyRequests, errRateLimitExceeded, message, wait)
+			return
+		}
+
+		ctx = context.WithValue(withClient(ctx, client), clientLimitsKey{}, limited)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// AdminOnly restricts a route to admin API keys. It runs after Middleware; while
+// authentication is disabled the route is open like every other.
+func (a *Authenticator) AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil {
+			c.Next()
+			return
+		}
+		if cfg, _ := a.settings(); !cfg.Enabled {
+			c.Next()
+			return
+		}
+
+		limited, ok := c.Request.Context().Value(clientLimitsKey{}).(clientLimits)
+		if !ok || !limited.limits.Admin {
+			metrics.ClientRequestsRejected.WithLabelValues(clientFromContext(c.Request.Context()), "not_admin").Inc()
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin API key required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allow applies the limits of the client a request was authenticated as to one more
+// request on the same connection, e.g. a message on a WebSocket. It returns the error to
+// answer with when the request is over a limit. Connections accepted while authentication
+// was disabled are not limited.
+func (a *Authenticator) Allow(ctx context.Context) *provider.RPCError {
+	if a == nil {
+		return nil
+	}
+	cfg, _ := a.settings()
+	limited, ok := ctx.Value(clientLimitsKey{}).(clientLimits)
+	if !cfg.Enabled || !ok {
+		return nil
+	}
+
+	reason, message, wait := a.take(ctx, cfg, limited, 1)
+	if wait <= 0 {
+		return nil
+	}
+	metrics.ClientRequestsRejected.WithLabelValues(limited.client, reason).Inc()
+	return &provider.RPCError{Code: errRateLimitExceeded, Message: retryMessage(message, wait)}
+}
+
+// clientLimits is what a request was authenticated as, kept for later requests on the
+// same connection
+type clientLimits struct {
+	client string
+	ip     string
+	limits config.ClientKeyConfig
+}
+
+type clientLimitsKey struct{}
+
+// take charges n requests to the IP rate limit and the API key's rate limit and quotas.
+// Over a limit, it returns the metric reason, a message for the client and how long to wait.
+func (a *Authenticator) take(ctx context.Context, cfg config.AuthConfig, l clientLimits, n int) (string, string, time.Duration) {
+	if wait := a.limiter.Take(ctx, "ip:"+l.ip, cfg.IPRequestsPerSecond, float64(n)); wait > 0 {
+		return "ip_rate", "Too many requests for this IP", wait
+	}
+	if l.client == anonymousClient {
+		return "", "", 0
+	}
+	if wait := a.limiter.Take(ctx, "key:"+l.client, l.limits.RequestsPerSecond, float64(n)); wait > 0 {
+		return "key_rate", "Too many requests for this API key", wait
+	}
+	if wait := a.limiter.UseQuota(ctx, l.client, int64(n), l.limits.DailyQuota, l.limits.MonthlyQuota); wait > 0 {
+		return "quota", "API key quota exhausted", wait
+	}
+	return "", "", 0
+}
+
+// lookup finds an API key in the configured keys, then in Redis
+func (a *Authenticator) lookup(ctx context.Context, keys map[string]config.ClientKeyConfig, secret string) (*config.ClientKeyConfig, bool) {
+	if key, ok := keys[secret]; ok {
+		return &key, true
+	}
+
+	a.mu.Lock()
+	entry, ok := a.cached[secret]
+	a.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.key, entry.key != nil
+	}
+
+	var key *config.ClientKeyConfig
+	val, err := a.redis.Get(ctx, apiKeyPrefix+secret).Bytes()
+	switch {
+	case err == redis.Nil:
+	case err != nil:
+		// Do not remember the failure: the key may well exist
+		log.Printf("[AUTH] Failed to look up API key: %v", err)
+		return nil, false
+	default:
+		var stored config.ClientKeyConfig
+		if err := json.Unmarshal(val, &stored); err != nil || stored.Name == "" {
+			log.Printf("[AUTH] Ignoring malformed API key record: %v", err)
+		} else {
+			key = &stored
+		}
+	}
+
+	a.mu.Lock()
+	if len(a.cached) >= maxCachedKeys {
+		// Unknown keys are cached too, so bound the memory a client sending random keys can use
+		a.cached = make(map[string]cachedKey)
+	}
+	a.cached[secret] = cachedKey{key: key, expires: time.Now().Add(apiKeyCacheTTL)}
+	a.mu.Unlock()
+	return key, key != nil
+}
+
+// reject aborts the request with a JSON-RPC error; rate limit rejections carry Retry-After
+func (a *Authenticator) reject(c *gin.Context, status, code int, message string, wait time.Duration) {
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		message = retryMessage(message, wait)
+	}
+	writeRPCResponse(c, status, newErrorResponse(requestID(c), code, message))
+	c.Abort()
+}
+
+// retryMessage tells a throttled client how long to wait
+func retryMessage(message string, wait time.Duration) string {
+	return fmt.Sprintf("%s, retry after %v", message, wait.Round(time.Millisecond))
+}
+
+// requestCount returns the number of JSON-RPC requests in the body (1 for anything but a
+// batch) and leaves the body in place for the handler
+func requestCount(c *gin.Context) int {
+	if c.Request.Body == nil {
+		return 1
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || !isBatch(body) {
+		return 1
+	}
+	var elements []json.RawMessage
+	if json.Unmarshal(body, &elements) != nil || len(elements) == 0 {
+		return 1
+	}
+	return len(elements)
+}
+
+// requestID returns the id of a single JSON-RPC request body, or nil
+func requestID(c *gin.Context) json.RawMessage {
+	if c.Request.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || isBatch(body) {
+		return nil
+	}
+	var req struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(body, &req) != nil || !provider.ValidID(req.ID) {
+		return nil
+	}
+	return req.ID
+}
+
+type clientContextKey struct{}
+
+// withClient returns a context carrying the client name used in metric labels
+func withClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+// clientFromContext returns the client a request belongs to
+func clientFromContext(ctx context.Context) string {
+	if client, ok := ctx.Value(clientContextKey{}).(string); ok {
+		return client
+	}
+	return anonymousClient
+}
+
+// countRequest counts a request outcome for the client the context belongs to
+func countRequest(ctx context.Context, providerName, method, status string) {
+	metrics.RequestsTotal.WithLabelValues(providerName, method, status, clientFromContext(ctx)).Inc()
+}
+
+// addCost adds the cost of one request for method sent to prov to the client the context
+// belongs to and to the provider's spend budgets
+func addCost(ctx context.Context, p *pool.ProviderPool, prov provider.Provider, method string) {
+	cost := p.Cost(prov, method)
+	metrics.TotalCostUSD.WithLabelValues(prov.Name(), method, clientFromContext(ctx)).Add(cost)
+	p.RecordSpend(ctx, prov.Name(), cost)
+}
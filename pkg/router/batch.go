@@ -0,0 +1,214 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// isBatch reports whether the request body is a JSON-RPC batch (a JSON array)
+func isBatch(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// newErrorResponse builds a JSON-RPC error response for the given request id
+func newErrorResponse(id json.RawMessage, code int, message string) *provider.RPCResponse {
+	return &provider.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &provider.RPCError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// handleBatch handles a JSON-RPC 2.0 batch. Cache hits and invalid elements are answered
+// locally, the rest is forwarded upstream and the responses are returned in request order.
+func (h *Handler) handleBatch(c *gin.Context, body []byte, session string, minSlot uint64) {
+	start := time.Now()
+	ctx := c.Request.Context()
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		log.Printf("[ERROR] Invalid JSON-RPC batch: %v", err)
+		writeRPCResponse(c, http.StatusBadRequest, newErrorResponse(nil, -32700, "Parse error: invalid JSON"))
+		return
+	}
+
+	if len(elements) == 0 {
+		writeRPCResponse(c, http.StatusBadRequest, newErrorResponse(nil, -32600, "Invalid Request: empty batch"))
+		return
+	}
+
+	responses := make([]*provider.RPCResponse, len(elements))
+	var forward []*provider.RPCRequest
+	var forwardIdx []int
+	var forwardRoutes []*pool.Route
+
+	for i, raw := range elements {
+		req, errResp := parseRequest(raw)
+		if errResp != nil {
+			responses[i] = errResp
+			continue
+		}
+
+		route := h.rules.Match(req, c.Request.Header)
+
+		// Notifications are forwarded but get no entry in the response
+		if req.IsNotification() {
+			forward = append(forward, req)
+			forwardIdx = append(forwardIdx, i)
+			forwardRoutes = append(forwardRoutes, route)
+			continue
+		}
+
+		// Transactions are broadcast individually
+		if h.broadcaster.Handles(req.Method) {
+			responses[i], _ = h.executeBroadcast(pool.WithRoute(ctx, route), req)
+			continue
+		}
+
+		// Check Cache (FR-7)
+		if h.cacheHandler != nil {
+			cachedResp, err := h.cacheHandler.GetCachedResponse(ctx, req)
+			if err == nil && cachedResp != nil && !sessionStale(cachedResp, minSlot) {
+				log.Printf("[CACHE] Hit for method=%s id=%s (batch)", req.Method, req.ID)
+				responses[i] = cachedResp
+				continue
+			}
+		}
+
+		forward = append(forward, req)
+		forwardIdx = append(forwardIdx, i)
+		forwardRoutes = append(forwardRoutes, route)
+	}
+
+	if len(forward) > 0 {
+		results := h.executeRouted(c, forward, forwardRoutes)
+		latency := time.Since(start)
+
+		for j, res := range results {
+			req := forward[j]
+			idx := forwardIdx[j]
+
+			if res.Err != nil {
+				countRequest(ctx, res.Provider, req.Method, "error")
+				if !req.IsNotification() {
+					responses[idx] = newErrorResponse(req.ID, -32603, fmt.Sprintf("Internal error: %v", res.Err))
+				}
+				continue
+			}
+
+			// Record per-element metrics and cost
+			countRequest(ctx, res.Provider, req.Method, "success")
+			metrics.RequestDuration.WithLabelValues(res.Provider).Observe(latency.Seconds())
+			h.recordCost(ctx, res.Provider, req.Method)
+
+			if req.IsNotification() {
+				continue
+			}
+
+			// Store in Cache (FR-7)
+			if h.cacheHandler != nil {
+				h.cacheHandler.StoreResponse(ctx, req, res.Response)
+			}
+			h.sessions.Observe(ctx, session, res.Response)
+
+			responses[idx] = res.Response
+		}
+	}
+
+	log.Printf("[BATCH] size=%d forwarded=%d latency=%v", len(elements), len(forward), time.Since(start))
+
+	writeRPCBatch(c, responses)
+}
+
+// executeRouted forwards batch elements grouped by routing rule, so each group is only
+// sent to the providers its rule allows. Results are returned in the order of reqs.
+func (h *Handler) executeRouted(c *gin.Context, reqs []*provider.RPCRequest, routes []*pool.Route) []BatchResult {
+	ctx := c.Request.Context()
+	results := make([]BatchResult, len(reqs))
+
+	groups := make(map[string][]int)
+	var order []string
+	for i, route := range routes {
+		name := ""
+		if route != nil {
+			name = route.Name
+		}
+		if _, ok := groups[name]; !ok {
+			order = append(order, name)
+		}
+		groups[name] = append(groups[name], i)
+	}
+
+	var matched []string
+	for _, name := range order {
+		indices := groups[name]
+		route := routes[indices[0]]
+
+		groupReqs := make([]*provider.RPCRequest, len(indices))
+		for j, idx := range indices {
+			groupReqs[j] = reqs[idx]
+			if route != nil {
+				metrics.RoutingRuleMatches.WithLabelValues(route.Name, reqs[idx].Method).Inc()
+			}
+		}
+		if route != nil {
+			matched = append(matched, route.Name)
+		}
+
+		groupResults := h.retryHandler.ExecuteBatchWithRetry(pool.WithRoute(ctx, route), groupReqs)
+		for j, idx := range indices {
+			results[idx] = groupResults[j]
+		}
+	}
+
+	if len(matched) > 0 {
+		c.Header(routeHeader, strings.Join(matched, ","))
+	}
+
+	return results
+}
+
+// writeRPCBatch writes batch responses as a JSON array, passing upstream bytes through unchanged.
+// Nil entries (notifications) are skipped; a batch of only notifications gets no body.
+func writeRPCBatch(c *gin.Context, responses []*provider.RPCResponse) {
+	var out []*provider.RPCResponse
+	for _, resp := range responses {
+		if resp != nil {
+			out = append(out, resp)
+		}
+	}
+
+	if len(out) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	c.Writer.Write([]byte{'['})
+	for i, resp := range out {
+		if i > 0 {
+			c.Writer.Write([]byte{','})
+		}
+		if _, err := resp.WriteTo(c.Writer); err != nil {
+			log.Printf("[ERROR] Failed to write batch response: %v", err)
+			return
+		}
+	}
+	c.Writer.Write([]byte{']'})
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+func TestIsBatch(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{`[{"jsonrpc":"2.0","id":1,"method":"getSlot"}]`, true},
+		{" \n\t[]", true},
+		{`{"jsonrpc":"2.0","id":1,"method":"getSlot"}`, false},
+		{"", false},
+		{"   ", false},
+	}
+
+	for _, tt := range tests {
+		if got := isBatch([]byte(tt.body)); got != tt.want {
+			t.Errorf("isBatch(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestWriteRPCBatch(t *testing.T) {
+	upstream := func(data string, id string) *provider.RPCResponse {
+		resp, err := provider.ParseResponse([]byte(data))
+		if err != nil {
+			t.Fatalf("ParseResponse: %v", err)
+		}
+		resp.ID = json.RawMessage(id)
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		responses  []*provider.RPCResponse
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "upstream bytes with client ids",
+			responses: []*provider.RPCResponse{
+				upstream(`{"jsonrpc":"2.0","id":0,"result":{"context":{"slot":5},"value":1}}`, `"a"`),
+				upstream(`{"jsonrpc":"2.0","id":1,"result":2}`, `7`),
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"jsonrpc":"2.0","id":"a","result":{"context":{"slot":5},"value":1}},{"jsonrpc":"2.0","id":7,"result":2}]`,
+		},
+		{
+			name: "notifications are skipped",
+			responses: []*provider.RPCResponse{
+				nil,
+				upstream(`{"jsonrpc":"2.0","id":1,"result":true}`, `2`),
+				nil,
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"jsonrpc":"2.0","id":2,"result":true}]`,
+		},
+		{
+			name: "local errors",
+			responses: []*provider.RPCResponse{
+				newErrorResponse(nil, -32600, "Invalid Request"),
+				upstream(`{"jsonrpc":"2.0","id":1,"result":3}`, `1`),
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"Invalid Request"}},{"jsonrpc":"2.0","id":1,"result":3}]`,
+		},
+		{
+			name:       "only notifications",
+			responses:  []*provider.RPCResponse{nil, nil},
+			wantStatus: http.StatusNoContent,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			writeRPCBatch(c, tt.responses)
+			c.Writer.WriteHeaderNow()
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+const (
+	txKeyPrefix          = "tx:sig:"
+	txStatePending       = "pending"
+	txStateSent          = "sent"
+	broadcastTimeout     = 30 * time.Second
+	broadcastWaitTimeout = 5 * time.Second
+	broadcastPollDelay   = 100 * time.Millisecond
+)
+
+// errBroadcastInProgress is returned when another request or replica is still
+// broadcasting the same signed transaction
+var errBroadcastInProgress = errors.New("transaction broadcast already in progress")
+
+// Broadcaster sends transactions to several providers at once and deduplicates
+// retries of the same signed transaction by signature through Redis
+type Broadcaster struct {
+	retryHandler *RetryHandler
+	redis        *redis.Client
+	settings     atomic.Pointer[broadcastSettings] // swapped by Reload
+}
+
+// broadcastSettings is the broadcast config with its method and provider sets
+type broadcastSettings struct {
+	config.BroadcastConfig
+	methods   map[string]bool
+	providers map[string]bool
+}
+
+// NewBroadcaster creates a new transaction broadcaster
+func NewBroadcaster(retryHandler *RetryHandler, redisClient *redis.Client, cfg config.BroadcastConfig) *Broadcaster {
+	b := &Broadcaster{
+		retryHandler: retryHandler,
+		redis:        redisClient,
+	}
+	b.Reload(cfg)
+	return b
+}
+
+// Reload swaps in new broadcast settings
+func (b *Broadcaster) Reload(cfg config.BroadcastConfig) {
+	s := &broadcastSettings{
+		BroadcastConfig: cfg,
+		methods:         make(map[string]bool),
+		providers:       make(map[string]bool),
+	}
+	if cfg.Enabled {
+		for _, method := range cfg.Methods {
+			s.methods[method] = true
+		}
+	}
+	for _, name := range cfg.Providers {
+		s.providers[name] = true
+	}
+	b.settings.Store(s)
+}
+
+// Handles reports whether requests for method are broadcast
+func (b *Broadcaster) Handles(method string) bool {
+	return b != nil && b.settings.Load().methods[method]
+}
+
+// Broadcast sends req to every healthy provider and returns the first accepted response.
+// The remaining providers keep going in the background so the transaction can land
+// through any of them. If all providers reject it, the first rejection is returned.
+func (b *Broadcaster) Broadcast(ctx context.Context, req *provider.RPCRequest) (*provider.RPCResponse, string, error) {
+	sig, err := transactionSignature(req.Params)
+	if err != nil {
+		log.Printf("[BROADCAST] Cannot read signature, broadcasting without deduplication: %v", err)
+	} else {
+		sent, err := b.claim(ctx, sig)
+		if errors.Is(err, errBroadcastInProgress) {
+			return nil, "", err
+		}
+		if err != nil {
+			log.Printf("[BROADCAST] Deduplication unavailable for %s: %v", sig, err)
+		}
+		if sent {
+			log.Printf("[BROADCAST] Transaction %s already sent, not re-sending", sig)
+			metrics.BroadcastResults.WithLabelValues("", "deduplicated").Inc()
+			result, _ := json.Marshal(sig)
+			return &provider.RPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}, "", nil
+		}
+	}
+
+	allowed := b.settings.Load().providers
+	var targets []provider.Provider
+	for _, prov := range b.retryHandler.pool.Healthy(ctx) {
+		if len(allowed) > 0 && !allowed[prov.Name()] {
+			continue
+		}
+		if b.retryHandler.IsAvailable(prov.Name()) {
+			targets = append(targets, prov)
+		}
+	}
+	if len(targets) == 0 {
+		b.release(sig)
+		return nil, "", fmt.Errorf("no healthy providers available for broadcast")
+	}
+
+	// Detach from the client request: a disconnect must not stop the broadcast
+	bctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), broadcastTimeout)
+	results := make(chan hedgeResult, len(targets))
+	for _, prov := range targets {
+		go func(prov provider.Provider) {
+			resp, err := b.retryHandler.forward(bctx, prov, req)
+			b.record(bctx, prov, req.Method, resp, err)
+			results <- hedgeResult{resp: resp, prov: prov, err: err}
+		}(prov)
+	}
+
+	var rejected *hedgeResult
+	var lastErr error
+	for received := 1; received <= len(targets); received++ {
+		res := <-results
+		if res.err == nil && res.resp.Error == nil {
+			b.markSent(sig)
+			go func(remaining int) {
+				for i := 0; i < remaining; i++ {
+					<-results
+				}
+				cancel()
+			}(len(targets) - received)
+			return res.resp, res.prov.Name(), nil
+		}
+		if res.err != nil {
+			lastErr = res.err
+		} else if rejected == nil {
+			rejected = &res
+		}
+	}
+	cancel()
+	b.release(sig)
+
+	// A provider rejection (e.g. failed preflight) is a valid answer for the client
+	if rejected != nil {
+		return rejected.resp, rejected.prov.Name(), nil
+	}
+	return nil, "", fmt.Errorf("broadcast failed on all providers, last error: %v", lastErr)
+}
+
+// record counts one provider's broadcast outcome and its cost
+func (b *Broadcaster) record(ctx context.Context, prov provider.Provider, method string, resp *provider.RPCResponse, err error) {
+	result := "accepted"
+	switch {
+	case err != nil:
+		result = "failed"
+	case resp.Error != nil:
+		result = "rejected"
+	}
+	metrics.BroadcastResults.WithLabelValues(prov.Name(), result).Inc()
+
+	// Every provider that answered was billed, whether or not it was the one returned
+	if err == nil {
+		addCost(ctx, b.retryHandler.pool, prov, method)
+	}
+}
+
+// claim reserves a signature for broadcasting. It reports whether the transaction was
+// already sent (by this or another replica) and must not be re-sent. While another
+// broadcast of the signature is pending it waits for its outcome.
+func (b *Broadcaster) claim(ctx context.Context, sig string) (bool, error) {
+	key := txKeyPrefix + sig
+	ttl := b.settings.Load().DedupTTL
+	deadline := time.Now().Add(broadcastWaitTimeout)
+
+	for {
+		ok, err := b.redis.SetNX(ctx, key, txStatePending, ttl).Result()
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, nil
+		}
+
+		state, err := b.redis.Get(ctx, key).Result()
+		if err == redis.Nil {
+			// The other broadcast failed and released the claim; try again
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if state == txStateSent {
+			return true, nil
+		}
+
+		if time.Now().After(deadline) {
+			return false, errBroadcastInProgress
+		}
+		select {
+		case <-time.After(broadcastPollDelay):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// markSent records that a signature was accepted by at least one provider
+func (b *Broadcaster) markSent(sig string) {
+	if sig == "" {
+		return
+	}
+	if err := b.redis.Set(context.Background(), txKeyPrefix+sig, txStateSent, b.settings.Load().DedupTTL).Err(); err != nil {
+		log.Printf("[BROADCAST] Failed to record signature %s: %v", sig, err)
+	}
+}
+
+// release drops the claim on a signature so the client can retry the transaction
+func (b *Broadcaster) release(sig string) {
+	if sig == "" {
+		return
+	}
+	b.redis.Del(context.Background(), txKeyPrefix+sig)
+}
@@ -4,63 +4,154 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/cache"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
 )
 
-// CacheHandler handles caching of RPC responses
+// cacheHeader marks responses served from an expired cache entry, with the policy that allowed it
+const cacheHeader = "X-Heimdall-Cache"
+
+// Stale serving modes, as reported in cacheHeader and metrics
+const (
+	staleWhileRevalidate = "stale-while-revalidate"
+	staleIfError         = "stale-if-error"
+)
+
+// defaultCommitment is the commitment Solana nodes apply when a request does not set one
+const defaultCommitment = "finalized"
+
+// legacyCommitments maps deprecated commitment names to their current equivalents
+var legacyCommitments = map[string]string{
+	"recent":       "processed",
+	"single":       "confirmed",
+	"singleGossip": "confirmed",
+	"root":         "finalized",
+	"max":          "finalized",
+}
+
+// alwaysImmutable methods return the same value regardless of commitment
+var alwaysImmutable = map[string]bool{
+	"getGenesisHash":   true,
+	"getEpochSchedule": true,
+}
+
+// CacheHandler handles caching of RPC responses. Entries live in a shared backend (L2),
+// optionally fronted by a bounded in-process LRU (L1) that saves the backend round trip.
 type CacheHandler struct {
-	redis  *redis.Client
-	config config.CachingConfig
+	backend      cache.Backend
+	l1           *cache.Memory
+	config       atomic.Pointer[config.CachingConfig] // swapped by Reload
+	revalidating sync.Map                             // cache keys being refreshed in the background
 }
 
-// NewCacheHandler creates a new cache handler
-func NewCacheHandler(redisClient *redis.Client, cfg config.CachingConfig) *CacheHandler {
-	return &CacheHandler{
-		redis:  redisClient,
-		config: cfg,
+// NewCacheHandler creates a new cache handler on top of the given backend
+func NewCacheHandler(backend cache.Backend, cfg config.CachingConfig) *CacheHandler {
+	h := &CacheHandler{backend: backend}
+	h.config.Store(&cfg)
+	if cfg.Compression.Enabled {
+		h.backend = cache.NewCompressed(backend, cfg.Compression.MinSize)
+	}
+	if cfg.L1.Enabled {
+		h.l1 = cache.NewMemory(cfg.L1.MaxEntries, cfg.L1.MaxBytes)
 	}
+	return h
 }
 
-// GetCachedResponse attempts to retrieve a cached response for the given request
+// cacheEntry is a cached response with the time it was stored and how long it stays fresh
+type cacheEntry struct {
+	StoredAt time.Time       `json:"stored_at"`
+	TTL      time.Duration   `json:"ttl"` // 0 = fresh forever
+	Response json.RawMessage `json:"response"`
+	Negative bool            `json:"negative,omitempty"` // a cached error result, never served stale
+}
+
+// GetCachedResponse attempts to retrieve a fresh cached response for the given request
 func (h *CacheHandler) GetCachedResponse(ctx context.Context, req *provider.RPCRequest) (*provider.RPCResponse, error) {
-	if !h.config.Enabled {
-		return nil, nil
+	resp, expired, err := h.Lookup(ctx, req)
+	if err != nil || expired > 0 {
+		return nil, err
 	}
+	return resp, nil
+}
 
-	ttl, exists := h.config.Methods[req.Method]
-	if !exists || ttl <= 0 {
-		return nil, nil
+// Lookup returns the cached response for req, if any, and how long ago it expired
+// (0 while fresh). Expired entries are kept as long as the method's stale policy allows
+// serving them; callers decide whether to do so.
+func (h *CacheHandler) Lookup(ctx context.Context, req *provider.RPCRequest) (*provider.RPCResponse, time.Duration, error) {
+	if !h.cacheable(req) {
+		return nil, 0, nil
 	}
 
 	key := h.generateKey(req)
-	val, err := h.redis.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return nil, nil
+	val, tier, err := h.get(ctx, key)
+	if errors.Is(err, cache.ErrMiss) {
+		metrics.CacheLookups.WithLabelValues(req.Method, "miss").Inc()
+		return nil, 0, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	metrics.CacheLookups.WithLabelValues(req.Method, tier).Inc()
 
-	var resp provider.RPCResponse
-	if err := json.Unmarshal([]byte(val), &resp); err != nil {
-		return nil, err
+	var entry cacheEntry
+	if err := json.Unmarshal(val, &entry); err != nil || len(entry.Response) == 0 {
+		return nil, 0, nil
+	}
+
+	// Keep entries found in L2 close for the next lookup
+	if tier == "l2" {
+		h.storeL1(ctx, key, req.Method, val, h.expiry(req.Method, entry))
+	}
+
+	resp, err := provider.ParseResponse(entry.Response)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The entry was stored for another client; answer with this request's id
+	resp.ID = req.ID
+
+	var expired time.Duration
+	if entry.TTL > 0 {
+		if age := time.Since(entry.StoredAt); age > entry.TTL {
+			expired = age - entry.TTL
+		}
+	}
+	if expired > 0 && entry.Negative {
+		return nil, 0, nil
 	}
+	return resp, expired, nil
+}
 
-	return &resp, nil
+// Reload swaps in new caching settings. The backend, L1 and compression settings are
+// fixed at startup.
+func (h *CacheHandler) Reload(cfg config.CachingConfig) {
+	h.config.Store(&cfg)
+}
+
+// StalePolicy returns how long expired entries of a method may still be served
+func (h *CacheHandler) StalePolicy(method string) config.StalePolicy {
+	return h.config.Load().Stale[method]
 }
 
 // StoreResponse caches a response for the given request if the method is cacheable
 func (h *CacheHandler) StoreResponse(ctx context.Context, req *provider.RPCRequest, resp *provider.RPCResponse) error {
-	if !h.config.Enabled {
+	if !h.cacheable(req) {
 		return nil
 	}
 
-	ttl, exists := h.config.Methods[req.Method]
-	if !exists || ttl <= 0 {
+	ttl, ok := h.ttlFor(req, resp)
+	if !ok {
 		return nil
 	}
 
@@ -70,12 +161,189 @@ func (h *CacheHandler) StoreResponse(ctx context.Context, req *provider.RPCReque
 		return err
 	}
 
-	return h.redis.Set(ctx, key, data, ttl).Err()
+	entry := cacheEntry{StoredAt: time.Now(), TTL: ttl, Response: data, Negative: resp.Error != nil}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Keep the entry past its TTL for as long as it may be served stale
+	h.storeL1(ctx, key, req.Method, encoded, h.expiry(req.Method, entry))
+
+	// A TTL of 0 stores the entry without expiry
+	return h.backend.Set(ctx, key, encoded, h.retention(req.Method, entry))
+}
+
+// get reads a cache entry from L1, then from the backend. It reports the tier that
+// answered ("l1" or "l2") or returns cache.ErrMiss.
+func (h *CacheHandler) get(ctx context.Context, key string) ([]byte, string, error) {
+	if h.l1 != nil {
+		if val, err := h.l1.Get(ctx, key); err == nil {
+			return val, "l1", nil
+		}
+	}
+
+	val, err := h.backend.Get(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return val, "l2", nil
+}
+
+// storeL1 keeps an entry in the in-process cache until it expires (zero = never),
+// but for at most the method's L1 TTL cap
+func (h *CacheHandler) storeL1(ctx context.Context, key, method string, val []byte, expiresAt time.Time) {
+	if h.l1 == nil {
+		return
+	}
+
+	cfg := h.config.Load()
+	limit := cfg.L1.MaxTTL
+	if methodLimit, ok := cfg.L1.MethodTTLs[method]; ok {
+		limit = methodLimit
+	}
+	if limit <= 0 {
+		return
+	}
+
+	ttl := limit
+	if !expiresAt.IsZero() {
+		ttl = min(limit, time.Until(expiresAt))
+	}
+	if ttl <= 0 {
+		return
+	}
+	h.l1.Set(ctx, key, val, ttl)
+
+	entries, size := h.l1.Len()
+	metrics.CacheL1Entries.Set(float64(entries))
+	metrics.CacheL1Bytes.Set(float64(size))
+}
+
+// retention returns how long an entry is kept, including the time it may be served
+// stale (0 = forever)
+func (h *CacheHandler) retention(method string, entry cacheEntry) time.Duration {
+	if entry.TTL <= 0 || entry.Negative {
+		return entry.TTL
+	}
+	policy := h.config.Load().Stale[method]
+	return entry.TTL + max(policy.StaleWhileRevalidate, policy.StaleIfError)
+}
+
+// expiry returns when an entry is dropped (zero = never)
+func (h *CacheHandler) expiry(method string, entry cacheEntry) time.Time {
+	retain := h.retention(method, entry)
+	if retain == 0 {
+		return time.Time{}
+	}
+	return entry.StoredAt.Add(retain)
+}
+
+// startRevalidation claims the background refresh of a request's cache entry.
+// It returns false while another refresh of the same entry is running.
+func (h *CacheHandler) startRevalidation(req *provider.RPCRequest) bool {
+	_, running := h.revalidating.LoadOrStore(h.generateKey(req), true)
+	return !running
+}
+
+// endRevalidation releases the claim taken by startRevalidation
+func (h *CacheHandler) endRevalidation(req *provider.RPCRequest) {
+	h.revalidating.Delete(h.generateKey(req))
+}
+
+// cacheable reports whether a request may be served from or stored in the cache at all
+func (h *CacheHandler) cacheable(req *provider.RPCRequest) bool {
+	cfg := h.config.Load()
+	if !cfg.Enabled {
+		return false
+	}
+
+	if _, ok := cfg.Immutable[req.Method]; ok {
+		return true
+	}
+
+	ttl, exists := cfg.Methods[req.Method]
+	if !exists || ttl <= 0 {
+		return false
+	}
+
+	// Never cache at a commitment whose cap is zero (e.g. processed)
+	if limit, capped := cfg.CommitmentTTLs[requestCommitment(req)]; capped && limit <= 0 {
+		return false
+	}
+	return true
+}
+
+// ttlFor decides how long a response may be cached, taking commitment and finality into account.
+// It returns false for combinations that must not be cached.
+func (h *CacheHandler) ttlFor(req *provider.RPCRequest, resp *provider.RPCResponse) (time.Duration, bool) {
+	cfg := h.config.Load()
+	commitment := requestCommitment(req)
+
+	// Deterministic errors (e.g. an account that does not exist) may be cached briefly
+	if resp.Error != nil {
+		return h.negativeTTL(commitment, resp.Error.Code)
+	}
+
+	// Missing results may change (e.g. a transaction that has not landed yet)
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return 0, false
+	}
+
+	// Finalized data of immutable methods never changes
+	if ttl, ok := cfg.Immutable[req.Method]; ok && (alwaysImmutable[req.Method] || commitment == "finalized") {
+		log.Printf("[CACHE] Storing immutable %s result (ttl=%v)", req.Method, ttl)
+		return ttl, true
+	}
+
+	ttl, exists := cfg.Methods[req.Method]
+	if !exists || ttl <= 0 {
+		return 0, false
+	}
+
+	if limit, capped := cfg.CommitmentTTLs[commitment]; capped {
+		if limit <= 0 {
+			return 0, false
+		}
+		if limit < ttl {
+			ttl = limit
+		}
+	}
+
+	return ttl, true
+}
+
+// negativeTTL returns how long an error result may be cached. Only the configured,
+// deterministic error codes are cached, and never longer than the commitment allows.
+func (h *CacheHandler) negativeTTL(commitment string, code int) (time.Duration, bool) {
+	cfg := h.config.Load()
+	negative := cfg.Negative
+	if !negative.Enabled || !slices.Contains(negative.Codes, code) {
+		return 0, false
+	}
+
+	ttl := negative.TTL
+	if limit, capped := cfg.CommitmentTTLs[commitment]; capped && limit < ttl {
+		ttl = limit
+	}
+	return ttl, ttl > 0
+}
+
+// requestCommitment returns the commitment requested in the params config object
+func requestCommitment(req *provider.RPCRequest) string {
+	_, cfg := paramShape(req.Params)
+	var commitment string
+	if raw, ok := cfg["commitment"]; ok && json.Unmarshal(raw, &commitment) == nil && commitment != "" {
+		if current, ok := legacyCommitments[commitment]; ok {
+			return current
+		}
+		return commitment
+	}
+	return defaultCommitment
 }
 
-// generateKey creates a unique cache key based on the RPC method and parameters
+// generateKey creates a unique cache key based on the RPC method and canonical parameters
 func (h *CacheHandler) generateKey(req *provider.RPCRequest) string {
-	paramsJSON, _ := json.Marshal(req.Params)
-	hash := sha256.Sum256(paramsJSON)
+	hash := sha256.Sum256(canonicalParams(req.Method, req.Params))
 	return fmt.Sprintf("rpc:cache:%s:%x", req.Method, hash[:8])
 }
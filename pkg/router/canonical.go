@@ -0,0 +1,90 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// configDefaults lists config object fields every method treats the same when omitted
+var configDefaults = map[string]string{
+	"commitment": `"finalized"`,
+}
+
+// methodConfigDefaults lists per-method config object fields and their default values
+var methodConfigDefaults = map[string]map[string]string{
+	"getBlock": {
+		"encoding":           `"json"`,
+		"transactionDetails": `"full"`,
+		"rewards":            `true`,
+	},
+	"getTransaction": {
+		"encoding": `"json"`,
+	},
+	"getSignaturesForAddress": {
+		"limit": `1000`,
+	},
+}
+
+// canonicalParams returns params in a canonical form for cache keys, so that equivalent
+// requests share an entry: object keys are sorted and whitespace dropped, numbers keep
+// their exact text, legacy commitment names are mapped to current ones, and config
+// fields that equal Solana's defaults are removed along with a config object left empty.
+func canonicalParams(method string, params json.RawMessage) []byte {
+	trimmed := bytes.TrimSpace(params)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return []byte("[]")
+	}
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return trimmed
+	}
+
+	switch p := value.(type) {
+	case map[string]interface{}:
+		// By-name params are the config object itself
+		normalizeConfig(method, p)
+		if len(p) == 0 {
+			return []byte("[]")
+		}
+	case []interface{}:
+		if n := len(p); n > 0 {
+			if cfg, ok := p[n-1].(map[string]interface{}); ok {
+				normalizeConfig(method, cfg)
+				if len(cfg) == 0 {
+					value = p[:n-1]
+				}
+			}
+		}
+	}
+
+	out, err := json.Marshal(value)
+	if err != nil {
+		return trimmed
+	}
+	return out
+}
+
+// normalizeConfig rewrites a config object in place
+func normalizeConfig(method string, cfg map[string]interface{}) {
+	if commitment, ok := cfg["commitment"].(string); ok {
+		if current, legacy := legacyCommitments[commitment]; legacy {
+			cfg["commitment"] = current
+		}
+	}
+
+	for key, value := range cfg {
+		def, ok := methodConfigDefaults[method][key]
+		if !ok {
+			def, ok = configDefaults[key]
+		}
+		if !ok {
+			continue
+		}
+		if encoded, err := json.Marshal(value); err == nil && string(encoded) == def {
+			delete(cfg, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCanonicalParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		params string
+		want   string
+	}{
+		{"no params", "getSlot", ``, `[]`},
+		{"null params", "getSlot", `null`, `[]`},
+		{"whitespace dropped", "getBalance", ` [ "addr" ] `, `["addr"]`},
+		{"keys sorted", "getAccountInfo", `["addr",{"encoding":"base64","commitment":"confirmed"}]`, `["addr",{"commitment":"confirmed","encoding":"base64"}]`},
+		{"numbers keep their text", "getBlock", `[1.50e2,{"maxSupportedTransactionVersion":0}]`, `[1.50e2,{"maxSupportedTransactionVersion":0}]`},
+		{"legacy commitment", "getBalance", `["addr",{"commitment":"single"}]`, `["addr",{"commitment":"confirmed"}]`},
+		{"default commitment removed", "getBalance", `["addr",{"commitment":"finalized"}]`, `["addr"]`},
+		{"legacy default commitment removed", "getBalance", `["addr",{"commitment":"max"}]`, `["addr"]`},
+		{"method defaults removed", "getBlock", `[100,{"encoding":"json","transactionDetails":"full","rewards":true}]`, `[100]`},
+		{"other method keeps field", "getAccountInfo", `["addr",{"encoding":"json"}]`, `["addr",{"encoding":"json"}]`},
+		{"non-default kept", "getSignaturesForAddress", `["addr",{"limit":10}]`, `["addr",{"limit":10}]`},
+		{"default limit removed", "getSignaturesForAddress", `["addr",{"limit":1000}]`, `["addr"]`},
+		{"by-name params", "getSlot", `{"commitment":"finalized"}`, `[]`},
+		{"by-name params kept", "getSlot", `{"commitment":"processed"}`, `{"commitment":"processed"}`},
+		{"nested objects untouched", "getProgramAccounts", `["prog",{"filters":[{"memcmp":{"offset":0,"bytes":"x"}}]}]`, `["prog",{"filters":[{"memcmp":{"bytes":"x","offset":0}}]}]`},
+		{"invalid JSON kept", "getSlot", ` [1, `, `[1,`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalParams(tt.method, json.RawMessage(tt.params)); string(got) != tt.want {
+				t.Errorf("canonicalParams(%s, %s) = %s, want %s", tt.method, tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalParamsEquivalence(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		a, b   string
+		same   bool
+	}{
+		{"omitted vs default commitment", "getBalance", `["addr"]`, `["addr",{"commitment":"finalized"}]`, true},
+		{"key order", "getAccountInfo", `["addr",{"encoding":"base64","commitment":"confirmed"}]`, `["addr",{"commitment":"confirmed","encoding":"base64"}]`, true},
+		{"legacy vs current name", "getBalance", `["addr",{"commitment":"recent"}]`, `["addr",{"commitment":"processed"}]`, true},
+		{"different commitment", "getBalance", `["addr",{"commitment":"confirmed"}]`, `["addr",{"commitment":"processed"}]`, false},
+		{"different address", "getBalance", `["a"]`, `["b"]`, false},
+		{"number text", "getBlock", `[100]`, `[1e2]`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := canonicalParams(tt.method, json.RawMessage(tt.a))
+			b := canonicalParams(tt.method, json.RawMessage(tt.b))
+			if (string(a) == string(b)) != tt.same {
+				t.Errorf("canonicalParams(%s) = %s, canonicalParams(%s) = %s, want same=%v", tt.a, a, tt.b, b, tt.same)
+			}
+		})
+	}
+}
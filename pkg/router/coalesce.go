@@ -0,0 +1,166 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+const (
+	inflightKeyPrefix = "rpc:inflight:"
+	coalescePollDelay = 10 * time.Millisecond
+)
+
+// fetchFunc sends a request upstream, caches the answer and returns it with the provider name
+type fetchFunc func(ctx context.Context) (*provider.RPCResponse, string, error)
+
+// flight is one upstream call shared by identical requests
+type flight struct {
+	done     chan struct{}
+	resp     *provider.RPCResponse
+	provider string
+	err      error
+}
+
+// Coalescer merges identical in-flight cacheable requests into a single upstream call.
+// Requests are keyed like the cache, so the shared call also fills the cache. With
+// distributed coalescing, a short Redis lock lets other replicas wait for the cache
+// entry instead of sending the same request upstream.
+type Coalescer struct {
+	cacheHandler *CacheHandler
+	redis        *redis.Client
+	config       atomic.Pointer[config.CoalescingConfig] // swapped by Reload
+	mu           sync.Mutex
+	flights      map[string]*flight
+}
+
+// NewCoalescer creates a new request coalescer
+func NewCoalescer(cacheHandler *CacheHandler, redisClient *redis.Client, cfg config.CoalescingConfig) *Coalescer {
+	c := &Coalescer{
+		cacheHandler: cacheHandler,
+		redis:        redisClient,
+		flights:      make(map[string]*flight),
+	}
+	c.config.Store(&cfg)
+	return c
+}
+
+// Reload swaps in new coalescing settings. Calls in flight finish with the old ones.
+func (c *Coalescer) Reload(cfg config.CoalescingConfig) {
+	c.config.Store(&cfg)
+}
+
+// Handles reports whether a request may share an upstream call with identical requests
+func (c *Coalescer) Handles(ctx context.Context, req *provider.RPCRequest) bool {
+	if c == nil || !c.config.Load().Enabled || c.cacheHandler == nil || !c.cacheHandler.cacheable(req) {
+		return false
+	}
+	// Session requests have their own slot requirement and cannot take another client's answer
+	_, hasSession := sessionFromContext(ctx)
+	return !hasSession
+}
+
+// Do runs fetch for req unless an identical request is already in flight, in which case
+// it waits for that call. Every caller receives the response with its own id; shared
+// reports whether the response came from another request's upstream call.
+func (c *Coalescer) Do(ctx context.Context, req *provider.RPCRequest, fetch fetchFunc) (*provider.RPCResponse, string, bool, error) {
+	key := c.key(ctx, req)
+
+	c.mu.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, "", false, ctx.Err()
+		}
+		metrics.CoalescedRequests.WithLabelValues(req.Method, "local").Inc()
+		if f.err != nil {
+			return nil, f.provider, true, f.err
+		}
+		return withID(f.resp, req.ID), f.provider, true, nil
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	// The call is shared, so one client disconnecting must not cancel it for the others
+	var shared bool
+	f.resp, f.provider, shared, f.err = c.lead(context.WithoutCancel(ctx), key, req, fetch)
+
+	c.mu.Lock()
+	delete(c.flights, key)
+	c.mu.Unlock()
+	close(f.done)
+
+	if f.err != nil {
+		return nil, f.provider, false, f.err
+	}
+	// Waiters read f.resp concurrently, so the leader also gets its own copy
+	return withID(f.resp, req.ID), f.provider, shared, nil
+}
+
+// lead performs the shared call. With distributed coalescing it first takes the Redis
+// lock for the key; if another replica holds it, the cached result of that replica's
+// call is used instead once it appears.
+func (c *Coalescer) lead(ctx context.Context, key string, req *provider.RPCRequest, fetch fetchFunc) (*provider.RPCResponse, string, bool, error) {
+	if cfg := c.config.Load(); cfg.Distributed {
+		lockKey := inflightKeyPrefix + key
+		locked, err := c.redis.SetNX(ctx, lockKey, 1, cfg.LockTTL).Result()
+		switch {
+		case err != nil:
+			log.Printf("[COALESCE] Lock unavailable for %s: %v", req.Method, err)
+		case locked:
+			defer c.redis.Del(ctx, lockKey)
+		default:
+			if resp := c.waitForCache(ctx, req); resp != nil {
+				metrics.CoalescedRequests.WithLabelValues(req.Method, "remote").Inc()
+				return resp, "", true, nil
+			}
+		}
+	}
+
+	resp, providerName, err := fetch(ctx)
+	return resp, providerName, false, err
+}
+
+// waitForCache polls the cache for the result of another replica's call until the lock expires
+func (c *Coalescer) waitForCache(ctx context.Context, req *provider.RPCRequest) *provider.RPCResponse {
+	deadline := time.Now().Add(c.config.Load().LockTTL)
+	for time.Now().Before(deadline) {
+		if resp, err := c.cacheHandler.GetCachedResponse(ctx, req); err == nil && resp != nil {
+			return resp
+		}
+		select {
+		case <-time.After(coalescePollDelay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// key identifies identical requests: same cache key and same routing rule
+func (c *Coalescer) key(ctx context.Context, req *provider.RPCRequest) string {
+	key := c.cacheHandler.generateKey(req)
+	if route := pool.RouteFromContext(ctx); route != nil {
+		key = route.Name + ":" + key
+	}
+	return key
+}
+
+// withID returns a copy of resp that answers the request with the given id
+func withID(resp *provider.RPCResponse, id json.RawMessage) *provider.RPCResponse {
+	out := *resp
+	out.ID = id
+	return &out
+}
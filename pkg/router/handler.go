@@ -1,13 +1,20 @@
 package router
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/budget"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/health"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/latency"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
@@ -18,14 +25,22 @@ type Handler struct {
 	pool         *pool.ProviderPool
 	retryHandler *RetryHandler
 	cacheHandler *CacheHandler
+	rules        *RuleEngine
+	broadcaster  *Broadcaster
+	sessions     *SessionTracker
+	coalescer    *Coalescer
 }
 
 // NewHandler creates a new request handler
-func NewHandler(pool *pool.ProviderPool, retryHandler *RetryHandler, cacheHandler *CacheHandler) *Handler {
+func NewHandler(pool *pool.ProviderPool, retryHandler *RetryHandler, cacheHandler *CacheHandler, rules *RuleEngine, broadcaster *Broadcaster, sessions *SessionTracker, coalescer *Coalescer) *Handler {
 	return &Handler{
 		pool:         pool,
 		retryHandler: retryHandler,
 		cacheHandler: cacheHandler,
+		rules:        rules,
+		broadcaster:  broadcaster,
+		sessions:     sessions,
+		coalescer:    coalescer,
 	}
 }
 
@@ -33,106 +48,280 @@ func NewHandler(pool *pool.ProviderPool, retryHandler *RetryHandler, cacheHandle
 func (h *Handler) HandleRPC(c *gin.Context) {
 	start := time.Now()
 
-	// Parse JSON-RPC request
-	var rpcReq provider.RPCRequest
-	if err := c.ShouldBindJSON(&rpcReq); err != nil {
-		log.Printf("[ERROR] Invalid JSON-RPC request: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"jsonrpc": "2.0",
-			"error": map[string]interface{}{
-				"code":    -32700,
-				"message": "Parse error: invalid JSON",
-			},
-			"id": nil,
-		})
+	body, err := c.GetRawData()
+	if err != nil || !json.Valid(body) {
+		log.Printf("[ERROR] Invalid JSON-RPC request body")
+		writeRPCResponse(c, http.StatusBadRequest, newErrorResponse(nil, -32700, "Parse error: invalid JSON"))
 		return
 	}
 
-	// Validate JSON-RPC request
-	if rpcReq.JSONRPC != "2.0" {
-		log.Printf("[ERROR] Invalid JSON-RPC version: %s", rpcReq.JSONRPC)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"jsonrpc": "2.0",
-			"error": map[string]interface{}{
-				"code":    -32600,
-				"message": "Invalid Request: jsonrpc must be 2.0",
-			},
-			"id": rpcReq.ID,
-		})
+	// Read-your-writes: a session is never answered from an older slot than it has seen
+	session, minSlot := h.startSession(c)
+
+	// JSON-RPC batches are handled element by element
+	if isBatch(body) {
+		h.handleBatch(c, body, session, minSlot)
 		return
 	}
 
-	if rpcReq.Method == "" {
-		log.Printf("[ERROR] Missing method in request")
-		c.JSON(http.StatusBadRequest, gin.H{
-			"jsonrpc": "2.0",
-			"error": map[string]interface{}{
-				"code":    -32600,
-				"message": "Invalid Request: method is required",
-			},
-			"id": rpcReq.ID,
-		})
+	// Parse and validate JSON-RPC request
+	req, errResp := parseRequest(body)
+	if errResp != nil {
+		log.Printf("[ERROR] Invalid JSON-RPC request: %s", errResp.Error.Message)
+		writeRPCResponse(c, http.StatusBadRequest, errResp)
+		return
+	}
+	rpcReq := *req
+
+	// Apply routing rules; the route travels with the request context
+	if route := h.rules.Match(&rpcReq, c.Request.Header); route != nil {
+		c.Request = c.Request.WithContext(pool.WithRoute(c.Request.Context(), route))
+		c.Header(routeHeader, route.Name)
+		metrics.RoutingRuleMatches.WithLabelValues(route.Name, rpcReq.Method).Inc()
+	}
+
+	// Notifications are executed but never answered
+	if rpcReq.IsNotification() {
+		h.handleNotification(c, &rpcReq)
+		return
+	}
+
+	// Transactions are broadcast to several providers
+	if h.broadcaster.Handles(rpcReq.Method) {
+		resp, status := h.executeBroadcast(c.Request.Context(), &rpcReq)
+		writeRPCResponse(c, status, resp)
 		return
 	}
 
 	// Check Cache (FR-7)
+	var staleResp *provider.RPCResponse
+	var policy config.StalePolicy
 	if h.cacheHandler != nil {
-		cachedResp, err := h.cacheHandler.GetCachedResponse(c.Request.Context(), &rpcReq)
-		if err == nil && cachedResp != nil {
-			log.Printf("[CACHE] Hit for method=%s id=%v", rpcReq.Method, rpcReq.ID)
-			c.JSON(http.StatusOK, cachedResp)
-			return
+		cachedResp, expired, err := h.cacheHandler.Lookup(c.Request.Context(), &rpcReq)
+		if err == nil && cachedResp != nil && !sessionStale(cachedResp, minSlot) {
+			if expired == 0 {
+				log.Printf("[CACHE] Hit for method=%s id=%s", rpcReq.Method, rpcReq.ID)
+				writeRPCResponse(c, http.StatusOK, cachedResp)
+				return
+			}
+
+			// Serve the expired entry and refresh it in the background
+			policy = h.cacheHandler.StalePolicy(rpcReq.Method)
+			if expired <= policy.StaleWhileRevalidate {
+				h.writeStale(c, &rpcReq, cachedResp, staleWhileRevalidate)
+				h.revalidate(c.Request.Context(), &rpcReq)
+				return
+			}
+			if expired <= policy.StaleIfError {
+				staleResp = cachedResp
+			}
 		}
 	}
 
 	// Forward request with retry and circuit breaking
-	resp, providerName, err := h.retryHandler.ExecuteWithRetry(c.Request.Context(), &rpcReq)
+	resp, providerName, shared, err := h.forward(c.Request.Context(), &rpcReq)
 
 	latency := time.Since(start)
 	if err != nil {
 
 		log.Printf("[ERROR] Failed to forward request: %v", err)
 
+		// Fall back to a recent cached answer rather than failing the client
+		if staleResp != nil {
+			countRequest(c.Request.Context(), providerName, rpcReq.Method, "error")
+			h.writeStale(c, &rpcReq, staleResp, staleIfError)
+			return
+		}
+
 		// Record error metrics
-		metrics.RequestsTotal.WithLabelValues(providerName, rpcReq.Method, "error").Inc()
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"jsonrpc": "2.0",
-			"error": map[string]interface{}{
-				"code":    -32603,
-				"message": fmt.Sprintf("Internal error: %v", err),
-			},
-			"id": rpcReq.ID,
-		})
+		countRequest(c.Request.Context(), providerName, rpcReq.Method, "error")
+
+		writeRPCResponse(c, http.StatusInternalServerError, newErrorResponse(rpcReq.ID, -32603, fmt.Sprintf("Internal error: %v", err)))
+		return
+	}
+
+	// Always answer with the client's id, whatever the provider echoed
+	resp.ID = rpcReq.ID
+
+	// Another identical request paid for the upstream call
+	if shared {
+		countRequest(c.Request.Context(), providerName, rpcReq.Method, "coalesced")
+		log.Printf("[REQUEST] method=%s provider=%s (coalesced) latency=%v", rpcReq.Method, providerName, latency)
+		h.sessions.Observe(c.Request.Context(), session, resp)
+		writeRPCResponse(c, http.StatusOK, resp)
 		return
 	}
 
 	// Record success metrics
-	metrics.RequestsTotal.WithLabelValues(providerName, rpcReq.Method, "success").Inc()
+	countRequest(c.Request.Context(), providerName, rpcReq.Method, "success")
 	metrics.RequestDuration.WithLabelValues(providerName).Observe(latency.Seconds())
 
 	// Record cost (FR-4)
-	// Find provider in pool to get its cost
-	for _, p := range h.pool.GetAll() {
-		if p.Name() == providerName {
-			metrics.TotalCostUSD.WithLabelValues(providerName).Add(p.CostPerRequest())
-			break
+	h.recordCost(c.Request.Context(), providerName, rpcReq.Method)
+
+	// Log request details
+	log.Printf("[REQUEST] method=%s provider=%s route=%s latency=%v", rpcReq.Method, providerName, c.Writer.Header().Get(routeHeader), latency)
+
+	h.sessions.Observe(c.Request.Context(), session, resp)
+
+	// Return response
+	writeRPCResponse(c, http.StatusOK, resp)
+}
+
+// writeStale answers a request with an expired cache entry, marking it in the cache header
+func (h *Handler) writeStale(c *gin.Context, req *provider.RPCRequest, resp *provider.RPCResponse, mode string) {
+	log.Printf("[CACHE] Serving stale %s result (%s) id=%s", req.Method, mode, req.ID)
+	metrics.StaleServed.WithLabelValues(req.Method, mode).Inc()
+	c.Header(cacheHeader, mode)
+	writeRPCResponse(c, http.StatusOK, resp)
+}
+
+// revalidate refreshes an expired cache entry in the background, one refresh per entry at a time
+func (h *Handler) revalidate(ctx context.Context, req *provider.RPCRequest) {
+	if !h.cacheHandler.startRevalidation(req) {
+		return
+	}
+
+	// The client already has its answer, so its disconnect must not stop the refresh
+	bgCtx := context.WithoutCancel(ctx)
+	bgReq := *req
+	go func() {
+		defer h.cacheHandler.endRevalidation(&bgReq)
+
+		_, providerName, shared, err := h.forward(bgCtx, &bgReq)
+		if err != nil {
+			log.Printf("[CACHE] Revalidation of %s failed: %v", bgReq.Method, err)
+			countRequest(bgCtx, providerName, bgReq.Method, "error")
+			return
+		}
+		if shared {
+			return
 		}
+		countRequest(bgCtx, providerName, bgReq.Method, "revalidate")
+		h.recordCost(bgCtx, providerName, bgReq.Method)
+	}()
+}
+
+// forward executes a request upstream and caches the answer. Identical in-flight cacheable
+// requests share one upstream call; shared reports whether this request got another's answer.
+func (h *Handler) forward(ctx context.Context, req *provider.RPCRequest) (*provider.RPCResponse, string, bool, error) {
+	fetch := func(ctx context.Context) (*provider.RPCResponse, string, error) {
+		resp, providerName, err := h.retryHandler.ExecuteWithRetry(ctx, req)
+
+		// Store in Cache (FR-7) before coalesced waiters and replicas are released
+		if err == nil && h.cacheHandler != nil {
+			h.cacheHandler.StoreResponse(ctx, req, resp)
+		}
+		return resp, providerName, err
 	}
 
-	// Log request details
-	log.Printf("[REQUEST] method=%s provider=%s latency=%v", rpcReq.Method, providerName, latency)
+	if !h.coalescer.Handles(ctx, req) {
+		resp, providerName, err := fetch(ctx)
+		return resp, providerName, false, err
+	}
+	return h.coalescer.Do(ctx, req, fetch)
+}
+
+// executeBroadcast broadcasts a transaction and returns the response and HTTP status to send
+func (h *Handler) executeBroadcast(ctx context.Context, req *provider.RPCRequest) (*provider.RPCResponse, int) {
+	start := time.Now()
 
-	// Update latency in Redis for routing optimization (Phase 2)
-	h.pool.UpdateLatency(c.Request.Context(), providerName, latency)
+	resp, providerName, err := h.broadcaster.Broadcast(ctx, req)
+	if err != nil {
+		log.Printf("[ERROR] Broadcast failed: %v", err)
+		countRequest(ctx, providerName, req.Method, "error")
+		return newErrorResponse(req.ID, -32603, fmt.Sprintf("Internal error: %v", err)), http.StatusInternalServerError
+	}
 
-	// Store in Cache (FR-7)
-	if h.cacheHandler != nil {
-		h.cacheHandler.StoreResponse(c.Request.Context(), &rpcReq, resp)
+	// Cost is recorded per provider by the broadcaster
+	latency := time.Since(start)
+	countRequest(ctx, providerName, req.Method, "success")
+	if providerName != "" {
+		metrics.RequestDuration.WithLabelValues(providerName).Observe(latency.Seconds())
 	}
+	log.Printf("[REQUEST] method=%s provider=%s (broadcast) latency=%v", req.Method, providerName, latency)
 
-	// Return response
-	c.JSON(http.StatusOK, resp)
+	resp.ID = req.ID
+	return resp, http.StatusOK
+}
+
+// startSession looks up the client's session and attaches its minimum context slot to
+// the request context. It returns the session id ("" if none) and that slot.
+func (h *Handler) startSession(c *gin.Context) (string, uint64) {
+	session := h.sessions.SessionID(c.Request.Header)
+	if session == "" {
+		return "", 0
+	}
+	minSlot := h.sessions.MinSlot(c.Request.Context(), session)
+	c.Request = c.Request.WithContext(h.sessions.WithSession(c.Request.Context(), minSlot))
+	return session, minSlot
+}
+
+// sessionStale reports whether a cached response is older than the session has seen
+func sessionStale(resp *provider.RPCResponse, minSlot uint64) bool {
+	return minSlot > 0 && resp.ContextSlot != 0 && resp.ContextSlot < minSlot
+}
+
+// parseRequest decodes and validates a single JSON-RPC request object.
+// On failure it returns the error response to send; its id is null when the id could not be read.
+func parseRequest(data []byte) (*provider.RPCRequest, *provider.RPCResponse) {
+	var req provider.RPCRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, newErrorResponse(nil, -32600, "Invalid Request")
+	}
+
+	if err := req.Validate(); err != nil {
+		id := req.ID
+		if !provider.ValidID(id) {
+			id = nil
+		}
+		return nil, newErrorResponse(id, -32600, fmt.Sprintf("Invalid Request: %v", err))
+	}
+
+	return &req, nil
+}
+
+// handleNotification forwards a notification upstream and replies with an empty body
+func (h *Handler) handleNotification(c *gin.Context, req *provider.RPCRequest) {
+	// Providers only answer requests with an id, so use a local one and drop the response
+	upstreamReq := *req
+	upstreamReq.ID = json.RawMessage("0")
+
+	_, providerName, err := h.retryHandler.ExecuteWithRetry(c.Request.Context(), &upstreamReq)
+	if err != nil {
+		log.Printf("[ERROR] Failed to forward notification: %v", err)
+		countRequest(c.Request.Context(), providerName, req.Method, "error")
+	} else {
+		countRequest(c.Request.Context(), providerName, req.Method, "success")
+		h.recordCost(c.Request.Context(), providerName, req.Method)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// writeRPCResponse writes a JSON-RPC response, passing upstream bytes through unchanged
+func writeRPCResponse(c *gin.Context, status int, resp *provider.RPCResponse) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(status)
+	if _, err := resp.WriteTo(c.Writer); err != nil {
+		log.Printf("[ERROR] Failed to write response: %v", err)
+	}
+}
+
+// recordCost adds the cost of a request for method on the named provider to the cost metrics
+func (h *Handler) recordCost(ctx context.Context, providerName, method string) {
+	recordCost(ctx, h.pool, providerName, method)
+}
+
+// recordCost looks up the named provider in the pool and adds the cost of a request for method
+func recordCost(ctx context.Context, p *pool.ProviderPool, providerName, method string) {
+	// Find provider in pool to get its cost
+	for _, prov := range p.GetAll() {
+		if prov.Name() == providerName {
+			addCost(ctx, p, prov, method)
+			return
+		}
+	}
 }
 
 // HealthCheck handles health check requests
@@ -151,11 +340,14 @@ func (h *Handler) GetSystemStatus(c *gin.Context) {
 	breakerStatuses := h.retryHandler.GetBreakerStatuses()
 
 	type ProviderStatus struct {
-		Name         string  `json:"name"`
-		Healthy      bool    `json:"healthy"`
-		Latency      int64   `json:"latency_ms"`
-		BreakerState string  `json:"breaker_state"`
-		Cost         float64 `json:"cost_per_req"`
+		Name         string          `json:"name"`
+		Healthy      bool            `json:"healthy"`
+		Health       string          `json:"health"`
+		Latency      int64           `json:"latency_ms"` // EWMA
+		LatencyStats *latency.Status `json:"latency,omitempty"`
+		BreakerState string          `json:"breaker_state"`
+		Cost         float64         `json:"cost_per_req"`
+		Budget       *budget.Status  `json:"budget,omitempty"`
 	}
 
 	var statusList []ProviderStatus
@@ -163,25 +355,56 @@ func (h *Handler) GetSystemStatus(c *gin.Context) {
 		// Get health from Redis
 		healthStatus, _ := health.GetProviderStatus(c.Request.Context(), h.pool.GetRedis(), p.Name())
 		isHealthy := healthStatus != nil && healthStatus.Healthy
+		state := "unknown"
+		if healthStatus != nil {
+			state = healthStatus.State
+		}
 
-		// Get latency from Redis
-		latency, _ := h.pool.GetLatency(c.Request.Context(), p.Name())
+		latencyStatus := h.pool.LatencyStatus(p.Name())
+		var stats latency.Stats
+		if latencyStatus != nil {
+			stats = latencyStatus.Stats
+		}
 
 		statusList = append(statusList, ProviderStatus{
 			Name:         p.Name(),
 			Healthy:      isHealthy,
-			Latency:      latency,
+			Health:       state,
+			Latency:      int64(math.Round(stats.EWMA)),
+			LatencyStats: latencyStatus,
 			BreakerState: breakerStatuses[p.Name()],
 			Cost:         p.CostPerRequest(),
+			Budget:       h.pool.BudgetStatus(c.Request.Context(), p.Name()),
 		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"providers": statusList,
-		"timestamp": time.Now().Unix(),
+		"providers":  statusList,
+		"budget":     h.pool.BudgetStatus(c.Request.Context(), ""),
+		"strategies": h.pool.Strategies(),
+		"tiers":      h.pool.TierStatus(),
+		"outliers":   h.pool.OutlierStatus(),
+		"timestamp":  time.Now().Unix(),
 	})
 }
 
+// SetStrategy switches the provider selection strategy of the pool (empty group) or of a method group
+func (h *Handler) SetStrategy(c *gin.Context) {
+	var body struct {
+		Group    string `json:"group"`
+		Strategy string `json:"strategy"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Strategy == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "strategy is required"})
+		return
+	}
+	if err := h.pool.SetStrategy(body.Group, body.Strategy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated", "group": body.Group, "strategy": body.Strategy})
+}
+
 // TripProvider handles manual circuit breaker tripping for demo
 func (h *Handler) TripProvider(c *gin.Context) {
 	providerName := c.Query("provider")
@@ -203,7 +426,7 @@ func (h *Handler) ResetChaos(c *gin.Context) {
 func (h *Handler) TestRPC(c *gin.Context) {
 	req := provider.RPCRequest{
 		JSONRPC: "2.0",
-		ID:      time.Now().Unix(),
+		ID:      json.RawMessage(strconv.FormatInt(time.Now().Unix(), 10)),
 		Method:  "getSlot",
 	}
 
@@ -212,23 +435,17 @@ func (h *Handler) TestRPC(c *gin.Context) {
 	latency := time.Since(start)
 
 	if err != nil {
-		metrics.RequestsTotal.WithLabelValues(providerName, req.Method, "error").Inc()
+		countRequest(c.Request.Context(), providerName, req.Method, "error")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Record success metrics & latency
-	metrics.RequestsTotal.WithLabelValues(providerName, req.Method, "success").Inc()
+	countRequest(c.Request.Context(), providerName, req.Method, "success")
 	metrics.RequestDuration.WithLabelValues(providerName).Observe(latency.Seconds())
-	h.pool.UpdateLatency(c.Request.Context(), providerName, latency)
 
 	// Record cost
-	for _, p := range h.pool.GetAll() {
-		if p.Name() == providerName {
-			metrics.TotalCostUSD.WithLabelValues(providerName).Add(p.CostPerRequest())
-			break
-		}
-	}
+	h.recordCost(c.Request.Context(), providerName, req.Method)
 
 	c.JSON(http.StatusOK, gin.H{
 		"provider": providerName,
@@ -0,0 +1,130 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// hedgeSettings is the hedging config with its set of hedged methods
+type hedgeSettings struct {
+	config.HedgingConfig
+	methods map[string]bool
+}
+
+// shouldHedge reports whether requests for method may be hedged
+func (r *RetryHandler) shouldHedge(method string) bool {
+	return r.hedging.Load().methods[method] && !config.NonIdempotentMethods[method]
+}
+
+// hedgeDelay returns how long to wait for a provider to answer method before sending a duplicate
+func (r *RetryHandler) hedgeDelay(name, method string) time.Duration {
+	hedging := r.hedging.Load()
+	delay, ok := r.pool.LatencyPercentile(name, method, hedging.Percentile)
+	if !ok {
+		return hedging.MaxDelay
+	}
+	if delay < hedging.MinDelay {
+		return hedging.MinDelay
+	}
+	if delay > hedging.MaxDelay {
+		return hedging.MaxDelay
+	}
+	return delay
+}
+
+// hedgeResult is the outcome of one side of a hedged request
+type hedgeResult struct {
+	resp *provider.RPCResponse
+	prov provider.Provider
+	err  error
+}
+
+// executeHedged sends req to primary and, if it has not answered within the hedge delay,
+// a duplicate to a second provider. The first successful answer wins and the other
+// attempt is cancelled. Both attempts are counted in cost and metrics.
+func (r *RetryHandler) executeHedged(ctx context.Context, req *provider.RPCRequest, primary provider.Provider, tried map[string]bool) (*provider.RPCResponse, string, error) {
+	hedgeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	launch := func(prov provider.Provider) {
+		go func() {
+			resp, err := r.forward(hedgeCtx, prov, req)
+			results <- hedgeResult{resp: resp, prov: prov, err: err}
+		}()
+	}
+
+	launch(primary)
+	inflight := 1
+	var secondary provider.Provider
+
+	timer := time.NewTimer(r.hedgeDelay(primary.Name(), req.Method))
+	defer timer.Stop()
+
+	var lastErr error
+	for inflight > 0 {
+		select {
+		case <-timer.C:
+			prov, err := r.pool.NextWithExclude(ctx, tried)
+			if err != nil || !r.IsAvailable(prov.Name()) {
+				continue
+			}
+			tried[prov.Name()] = true
+			secondary = prov
+			launch(secondary)
+			inflight++
+			log.Printf("[HEDGE] %s slow on %s, sending duplicate to %s", primary.Name(), req.Method, secondary.Name())
+
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				if secondary != nil {
+					r.recordHedge(req.Method, res.prov, secondary)
+					if inflight > 0 {
+						go r.recordLoser(context.WithoutCancel(ctx), req.Method, results)
+					}
+				}
+				return res.resp, res.prov.Name(), nil
+			}
+			lastErr = res.err
+
+			// Without a duplicate in flight, let the retry loop handle the failure
+			if secondary == nil {
+				return nil, "", lastErr
+			}
+			if res.prov == secondary {
+				metrics.HedgedRequests.WithLabelValues(secondary.Name(), req.Method, "failed").Inc()
+			}
+		}
+	}
+
+	return nil, "", lastErr
+}
+
+// recordHedge counts whether the duplicate won or lost. The winner's request and cost
+// are recorded by the caller, the loser's by recordLoser.
+func (r *RetryHandler) recordHedge(method string, winner, secondary provider.Provider) {
+	outcome := "lost"
+	if winner == secondary {
+		outcome = "won"
+	}
+	metrics.HedgedRequests.WithLabelValues(secondary.Name(), method, outcome).Inc()
+}
+
+// recordLoser waits for the losing side of a hedge, which is cancelled once the winner
+// returns, and records it. It was still sent and billed, unless it failed on its own:
+// failed attempts are accounted by forward.
+func (r *RetryHandler) recordLoser(ctx context.Context, method string, results <-chan hedgeResult) {
+	res := <-results
+	if res.err != nil && !errors.Is(res.err, context.Canceled) {
+		return
+	}
+	countRequest(ctx, res.prov.Name(), method, "hedged")
+	addCost(ctx, r.pool, res.prov, method)
+}
@@ -2,47 +2,55 @@ package router
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/breaker"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
 	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
-	"github.com/sony/gobreaker"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/ratelimit"
 )
 
 // RetryHandler handles requests with retries and circuit breaking
 type RetryHandler struct {
-	pool            *pool.ProviderPool
-	circuitBreakers map[string]*gobreaker.CircuitBreaker
-	forcedStates    map[string]string // "open" or "" (normal)
+	pool         *pool.ProviderPool
+	breakers     *breaker.Group
+	forcedStates map[string]string             // "open" or "" (normal)
+	hedging      atomic.Pointer[hedgeSettings] // swapped by Reload
+	limiter      *ratelimit.Limiter
 }
 
-// NewRetryHandler creates a new retry handler
-func NewRetryHandler(providerPool *pool.ProviderPool, providerNames []string) *RetryHandler {
-	cbs := make(map[string]*gobreaker.CircuitBreaker)
+// errRateLimited is returned when a provider has no rate limit budget left for a request
+var errRateLimited = errors.New("rate limit budget exhausted")
 
-	for _, name := range providerNames {
-		st := gobreaker.Settings{
-			Name:        name,
-			MaxRequests: 5,
-			Interval:    time.Minute,
-			Timeout:     time.Minute,
-			ReadyToTrip: func(counts gobreaker.Counts) bool {
-				return counts.ConsecutiveFailures >= 5
-			},
-			OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-				log.Printf("[CIRCUIT-BREAKER] Provider %s state changed from %s to %s", name, from, to)
-			},
-		}
-		cbs[name] = gobreaker.NewCircuitBreaker(st)
+// NewRetryHandler creates a new retry handler
+func NewRetryHandler(providerPool *pool.ProviderPool, breakers *breaker.Group, hedging config.HedgingConfig, limiter *ratelimit.Limiter) *RetryHandler {
+	r := &RetryHandler{
+		pool:         providerPool,
+		breakers:     breakers,
+		forcedStates: make(map[string]string),
+		limiter:      limiter,
 	}
+	r.Reload(hedging)
+	return r
+}
 
-	return &RetryHandler{
-		pool:            providerPool,
-		circuitBreakers: cbs,
-		forcedStates:    make(map[string]string),
+// Reload swaps in new hedging settings
+func (r *RetryHandler) Reload(hedging config.HedgingConfig) {
+	s := &hedgeSettings{HedgingConfig: hedging, methods: make(map[string]bool)}
+	if hedging.Enabled {
+		for _, method := range hedging.Methods {
+			s.methods[method] = true
+		}
 	}
+	r.hedging.Store(s)
 }
 
 // ExecuteWithRetry executes an RPC request with up to 3 retries and exponential backoff
@@ -53,6 +61,15 @@ func (r *RetryHandler) ExecuteWithRetry(ctx context.Context, req *provider.RPCRe
 
 	tried := make(map[string]bool)
 
+	// Method groups may select providers with their own strategy
+	ctx = pool.WithMethod(ctx, req.Method)
+
+	// Session requests may carry minContextSlot and must not be answered from an older slot
+	session, hasSession := sessionFromContext(ctx)
+	if hasSession {
+		req = session.prepare(req)
+	}
+
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		// Get next healthy provider, excluding already tried ones in this request
 		prov, err := r.pool.NextWithExclude(ctx, tried)
@@ -68,25 +85,20 @@ func (r *RetryHandler) ExecuteWithRetry(ctx context.Context, req *provider.RPCRe
 			continue
 		}
 
-		cb, ok := r.circuitBreakers[prov.Name()]
-		if !ok {
-			// Fallback if CB not initialized for some reason
-			resp, err := prov.ForwardRequest(ctx, req)
-			if err == nil {
-				return resp, prov.Name(), nil
-			}
-			lastErr = err
+		var resp *provider.RPCResponse
+		name := prov.Name()
+		if r.shouldHedge(req.Method) {
+			resp, name, err = r.executeHedged(ctx, req, prov, tried)
 		} else {
-			// Execute through circuit breaker
-			result, err := cb.Execute(func() (interface{}, error) {
-				return prov.ForwardRequest(ctx, req)
-			})
-
-			if err == nil {
-				return result.(*provider.RPCResponse), prov.Name(), nil
-			}
-			lastErr = err
+			resp, err = r.forward(ctx, prov, req)
 		}
+		if err == nil && hasSession && session.stale(resp) {
+			err = r.rejectStale(ctx, name, req.Method, session)
+		}
+		if err == nil {
+			return resp, name, nil
+		}
+		lastErr = err
 
 		log.Printf("[RETRY] Attempt %d failed for provider %s: %v", attempt+1, prov.Name(), lastErr)
 
@@ -104,19 +116,121 @@ func (r *RetryHandler) ExecuteWithRetry(ctx context.Context, req *provider.RPCRe
 	return nil, "", fmt.Errorf("max retries exceeded, last error: %v", lastErr)
 }
 
+// forward sends a single request to a provider through its circuit breaker
+// and records the attempt latency and outcome
+func (r *RetryHandler) forward(ctx context.Context, prov provider.Provider, req *provider.RPCRequest) (*provider.RPCResponse, error) {
+	if !r.limiter.Allow(ctx, prov.Name(), 1, r.limiter.Credits(prov.Name(), req.Method)) {
+		metrics.RateLimited.WithLabelValues(prov.Name(), "local").Inc()
+		return nil, fmt.Errorf("provider %s: %w", prov.Name(), errRateLimited)
+	}
+
+	start := time.Now()
+
+	var resp *provider.RPCResponse
+	var err error
+	if cb := r.breakers.Get(prov.Name()); cb != nil {
+		var result interface{}
+		result, err = cb.Execute(func() (interface{}, error) {
+			return prov.ForwardRequest(ctx, req)
+		})
+		if err == nil {
+			resp = result.(*provider.RPCResponse)
+		}
+	} else {
+		// Fallback if CB not initialized for some reason
+		resp, err = prov.ForwardRequest(ctx, req)
+	}
+	latency := time.Since(start)
+
+	if counted, failed := attemptOutcome(err); counted {
+		r.pool.ObserveOutcome(prov.Name(), failed, latency)
+	}
+	if err != nil {
+		r.checkFailed(ctx, prov, []*provider.RPCRequest{req}, err)
+		return nil, err
+	}
+
+	r.pool.ObserveLatency(prov.Name(), req.Method, latency)
+	return resp, nil
+}
+
+// attemptOutcome classifies an attempt for outlier detection. Attempts the breaker
+// refused, cancelled ones (e.g. the losing side of a hedge) and rate limited ones say
+// nothing about the provider and are not counted.
+func attemptOutcome(err error) (counted, failed bool) {
+	var rateLimited *provider.RateLimitError
+	switch {
+	case err == nil:
+		return true, false
+	case errors.Is(err, breaker.ErrOpenState), errors.Is(err, breaker.ErrTooManyRequests),
+		errors.Is(err, context.Canceled), errors.As(err, &rateLimited):
+		return false, false
+	}
+	return true, true
+}
+
+// checkFailed accounts for a failed attempt. Requests the provider answered with an
+// HTTP error were still billed, so their cost is recorded; retries add to the cost.
+func (r *RetryHandler) checkFailed(ctx context.Context, prov provider.Provider, reqs []*provider.RPCRequest, err error) {
+	var httpErr *provider.HTTPError
+	if errors.As(err, &httpErr) {
+		for _, req := range reqs {
+			addCost(ctx, r.pool, prov, req.Method)
+		}
+		return
+	}
+	r.checkRateLimited(ctx, prov.Name(), err)
+}
+
+// checkRateLimited cools a provider down when it rejected a request with HTTP 429
+func (r *RetryHandler) checkRateLimited(ctx context.Context, name string, err error) {
+	var rateLimited *provider.RateLimitError
+	if !errors.As(err, &rateLimited) {
+		return
+	}
+	log.Printf("[RATELIMIT] Provider %s rate limited us, cooling down for %v", name, rateLimited.RetryAfter)
+	metrics.RateLimited.WithLabelValues(name, "upstream").Inc()
+	r.limiter.Cooldown(context.WithoutCancel(ctx), name, rateLimited.RetryAfter)
+}
+
+// rejectStale accounts for a response that was behind the client session. The provider
+// is healthy, so its breaker is untouched, but the request was still billed.
+func (r *RetryHandler) rejectStale(ctx context.Context, name, method string, session sessionSlot) error {
+	metrics.StaleResponses.WithLabelValues(name, method).Inc()
+	countRequest(ctx, name, method, "stale")
+	for _, p := range r.pool.GetAll() {
+		if p.Name() == name {
+			addCost(ctx, r.pool, p, method)
+			break
+		}
+	}
+	return fmt.Errorf("provider %s has not reached session slot %d", name, session.minSlot)
+}
+
 // GetBreakerStatuses returns the current state of all circuit breakers
 func (r *RetryHandler) GetBreakerStatuses() map[string]string {
 	statuses := make(map[string]string)
-	for name, cb := range r.circuitBreakers {
-		state := cb.State().String()
+	for name, state := range r.breakers.States() {
+		statuses[name] = state.String()
 		if r.forcedStates[name] == "open" {
-			state = "FORCED OPEN"
+			statuses[name] = "FORCED OPEN"
 		}
-		statuses[name] = state
 	}
 	return statuses
 }
 
+// IsAvailable reports whether a provider can currently take traffic
+// (its circuit breaker is not open and it is not forced open)
+func (r *RetryHandler) IsAvailable(name string) bool {
+	if r.forcedStates[name] == "open" {
+		return false
+	}
+	if cb := r.breakers.Get(name); cb != nil && cb.State() == breaker.StateOpen {
+		return false
+	}
+	return true
+}
+
 // TripProvider manually forces a provider's circuit breaker to open (for demo)
 func (r *RetryHandler) TripProvider(name string) {
 	r.forcedStates[name] = "open"
@@ -128,3 +242,178 @@ func (r *RetryHandler) ResetChaos() {
 	r.forcedStates = make(map[string]string)
 	log.Printf("[CHAOS] All manual overrides RESET")
 }
+
+// BatchResult holds the outcome of a single element of a forwarded batch
+type BatchResult struct {
+	Response *provider.RPCResponse
+	Provider string
+	Err      error
+}
+
+// ExecuteBatchWithRetry forwards a batch upstream, splitting it to fit each provider's
+// batch limit and retrying only the elements that did not receive a response.
+// Results are returned in the same order as reqs.
+func (r *RetryHandler) ExecuteBatchWithRetry(ctx context.Context, reqs []*provider.RPCRequest) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	var lastErr error
+	maxRetries := 3
+	backoff := 100 * time.Millisecond
+
+	// Session requests may carry minContextSlot
+	if session, ok := sessionFromContext(ctx); ok {
+		prepared := make([]*provider.RPCRequest, len(reqs))
+		for i, req := range reqs {
+			prepared[i] = session.prepare(req)
+		}
+		reqs = prepared
+	}
+
+	pending := make([]int, len(reqs))
+	for i := range reqs {
+		pending[i] = i
+	}
+
+	tried := make(map[string]bool)
+
+retryLoop:
+	for attempt := 0; attempt < maxRetries && len(pending) > 0; attempt++ {
+		prov, err := r.pool.NextWithExclude(ctx, tried)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to select provider: %w", err)
+			break
+		}
+
+		tried[prov.Name()] = true
+
+		// Check if forced into open state (Demo Chaos)
+		if r.forcedStates[prov.Name()] == "open" {
+			log.Printf("[CHAOS] Skipping provider %s (Forced Open)", prov.Name())
+			continue
+		}
+
+		var failed []int
+		for _, chunk := range splitBatch(pending, prov.MaxBatchSize()) {
+			missing, err := r.forwardChunk(ctx, prov, reqs, chunk, results)
+			if err != nil {
+				lastErr = err
+				log.Printf("[RETRY] Batch attempt %d failed for provider %s (%d requests): %v", attempt+1, prov.Name(), len(chunk), err)
+			}
+			failed = append(failed, missing...)
+		}
+		pending = failed
+
+		// Exponential backoff
+		if len(pending) > 0 && attempt < maxRetries-1 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				lastErr = ctx.Err()
+				break retryLoop
+			}
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no response received")
+	}
+	for _, idx := range pending {
+		results[idx] = BatchResult{Err: fmt.Errorf("max retries exceeded, last error: %v", lastErr)}
+	}
+
+	return results
+}
+
+// forwardChunk sends one upstream batch and fills results for every element that was answered.
+// Upstream ids are replaced by the element index so responses can be matched regardless of
+// the order the provider returns them in or of duplicate client ids.
+// It returns the indices that still need a response.
+func (r *RetryHandler) forwardChunk(ctx context.Context, prov provider.Provider, reqs []*provider.RPCRequest, chunk []int, results []BatchResult) ([]int, error) {
+	upstream := make([]*provider.RPCRequest, len(chunk))
+	for i, idx := range chunk {
+		req := *reqs[idx]
+		req.ID = json.RawMessage(strconv.Itoa(idx))
+		upstream[i] = &req
+	}
+
+	credits := 0.0
+	for _, req := range upstream {
+		credits += r.limiter.Credits(prov.Name(), req.Method)
+	}
+	if !r.limiter.Allow(ctx, prov.Name(), len(upstream), credits) {
+		metrics.RateLimited.WithLabelValues(prov.Name(), "local").Inc()
+		return chunk, fmt.Errorf("provider %s: %w", prov.Name(), errRateLimited)
+	}
+
+	forward := func() (interface{}, error) {
+		return prov.ForwardBatch(ctx, upstream)
+	}
+
+	var result interface{}
+	var err error
+	if cb := r.breakers.Get(prov.Name()); cb != nil {
+		result, err = cb.Execute(forward)
+	} else {
+		result, err = forward()
+	}
+	// Batch latency is not comparable to that of single requests, so only the outcome counts
+	if counted, failed := attemptOutcome(err); counted {
+		r.pool.ObserveOutcome(prov.Name(), failed, 0)
+	}
+	if err != nil {
+		r.checkFailed(ctx, prov, upstream, err)
+		return chunk, err
+	}
+
+	answered := make(map[string]*provider.RPCResponse)
+	for _, resp := range result.([]*provider.RPCResponse) {
+		if resp != nil {
+			answered[string(resp.ID)] = resp
+		}
+	}
+
+	session, hasSession := sessionFromContext(ctx)
+
+	var missing []int
+	stale := 0
+	for _, idx := range chunk {
+		resp, ok := answered[strconv.Itoa(idx)]
+		if !ok {
+			missing = append(missing, idx)
+			continue
+		}
+		if hasSession && session.stale(resp) {
+			r.rejectStale(ctx, prov.Name(), reqs[idx].Method, session)
+			missing = append(missing, idx)
+			stale++
+			continue
+		}
+		resp.ID = reqs[idx].ID
+		results[idx] = BatchResult{Response: resp, Provider: prov.Name()}
+	}
+
+	if stale > 0 {
+		return missing, fmt.Errorf("provider %s has not reached session slot %d for %d of %d batch requests", prov.Name(), session.minSlot, stale, len(chunk))
+	}
+	if len(missing) > 0 {
+		return missing, fmt.Errorf("provider %s omitted %d of %d batch responses", prov.Name(), len(missing), len(chunk))
+	}
+	return nil, nil
+}
+
+// splitBatch splits indices into chunks of at most size elements (size <= 0 means no limit)
+func splitBatch(indices []int, size int) [][]int {
+	if size <= 0 || len(indices) <= size {
+		return [][]int{indices}
+	}
+
+	var chunks [][]int
+	for start := 0; start < len(indices); start += size {
+		end := start + size
+		if end > len(indices) {
+			end = len(indices)
+		}
+		chunks = append(chunks, indices[start:end])
+	}
+	return chunks
+}
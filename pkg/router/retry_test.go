@@ -0,0 +1,185 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/breaker"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// batchProvider answers batches locally. Each response echoes the upstream id and carries
+// the request's params as its result; responses come back in reverse order, and the
+// requests whose upstream id is in omit get no response.
+type batchProvider struct {
+	provider.Provider
+	name     string
+	maxBatch int
+	omit     map[string]bool
+
+	mu      sync.Mutex
+	batches [][]string // upstream ids of every batch received
+}
+
+func (p *batchProvider) Name() string            { return p.name }
+func (p *batchProvider) CostPerRequest() float64 { return 0 }
+func (p *batchProvider) MaxBatchSize() int       { return p.maxBatch }
+
+func (p *batchProvider) ForwardBatch(ctx context.Context, reqs []*provider.RPCRequest) ([]*provider.RPCResponse, error) {
+	var ids []string
+	var resps []*provider.RPCResponse
+	for i := len(reqs) - 1; i >= 0; i-- {
+		ids = append([]string{string(reqs[i].ID)}, ids...)
+		if p.omit[string(reqs[i].ID)] {
+			continue
+		}
+		resp, err := provider.ParseResponse([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, reqs[i].ID, reqs[i].Params)))
+		if err != nil {
+			return nil, err
+		}
+		resps = append(resps, resp)
+	}
+
+	p.mu.Lock()
+	p.batches = append(p.batches, ids)
+	p.mu.Unlock()
+	return resps, nil
+}
+
+// newBatchHandler builds a retry handler over the given providers without Redis, so there
+// is no health data, plan limit or budget. Providers are tried in the order given.
+func newBatchHandler(t *testing.T, providers ...provider.Provider) *RetryHandler {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Routing.Strategy = "priority"
+	for i, prov := range providers {
+		cfg.Providers = append(cfg.Providers, config.ProviderConfig{Name: prov.Name(), Priority: i + 1, Weight: 1})
+	}
+	p, err := pool.NewProviderPool(providers, nil, cfg, nil, nil)
+	if err != nil {
+		t.Fatalf("NewProviderPool: %v", err)
+	}
+	return NewRetryHandler(p, breaker.NewGroup(nil, nil), config.HedgingConfig{}, nil)
+}
+
+func TestSplitBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		indices []int
+		size    int
+		want    [][]int
+	}{
+		{"unlimited", []int{0, 1, 2}, 0, [][]int{{0, 1, 2}}},
+		{"fits", []int{0, 1, 2}, 3, [][]int{{0, 1, 2}}},
+		{"even", []int{0, 1, 2, 3}, 2, [][]int{{0, 1}, {2, 3}}},
+		{"remainder", []int{0, 1, 2, 3, 4}, 2, [][]int{{0, 1}, {2, 3}, {4}}},
+		{"single", []int{4, 7, 9}, 1, [][]int{{4}, {7}, {9}}},
+		{"retried subset", []int{1, 4, 6}, 2, [][]int{{1, 4}, {6}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitBatch(tt.indices, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitBatch(%v, %d) = %v, want %v", tt.indices, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteBatchWithRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxBatch    int
+		ids         []string // client ids, "" for a notification
+		omit        map[string]bool
+		wantBatches [][]string // batches sent to the first provider
+		wantResent  [][]string // batches sent to the second provider
+	}{
+		{
+			name:        "single chunk",
+			ids:         []string{`1`, `2`, `3`},
+			wantBatches: [][]string{{`0`, `1`, `2`}},
+		},
+		{
+			name:        "split to the batch limit",
+			maxBatch:    2,
+			ids:         []string{`"a"`, `"b"`, `"c"`, `"d"`, `"e"`},
+			wantBatches: [][]string{{`0`, `1`}, {`2`, `3`}, {`4`}},
+		},
+		{
+			name:        "duplicate client ids",
+			ids:         []string{`7`, `7`, `null`},
+			wantBatches: [][]string{{`0`, `1`, `2`}},
+		},
+		{
+			name:        "notifications",
+			maxBatch:    2,
+			ids:         []string{``, `1`, ``},
+			wantBatches: [][]string{{`0`, `1`}, {`2`}},
+		},
+		{
+			name:        "omitted responses are retried elsewhere",
+			ids:         []string{`1`, `2`, `3`},
+			omit:        map[string]bool{`1`: true},
+			wantBatches: [][]string{{`0`, `1`, `2`}},
+			wantResent:  [][]string{{`1`}},
+		},
+		{
+			name:        "omitted responses across chunks",
+			maxBatch:    2,
+			ids:         []string{`1`, `2`, `3`, `4`},
+			omit:        map[string]bool{`0`: true, `3`: true},
+			wantBatches: [][]string{{`0`, `1`}, {`2`, `3`}},
+			wantResent:  [][]string{{`0`, `3`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := &batchProvider{name: "first", maxBatch: tt.maxBatch, omit: tt.omit}
+			second := &batchProvider{name: "second", maxBatch: tt.maxBatch}
+			r := newBatchHandler(t, first, second)
+
+			reqs := make([]*provider.RPCRequest, len(tt.ids))
+			for i, id := range tt.ids {
+				reqs[i] = &provider.RPCRequest{JSONRPC: "2.0", Method: "getSlot", Params: json.RawMessage(fmt.Sprintf(`[%d]`, i))}
+				if id != "" {
+					reqs[i].ID = json.RawMessage(id)
+				}
+			}
+
+			results := r.ExecuteBatchWithRetry(context.Background(), reqs)
+			if !reflect.DeepEqual(first.batches, tt.wantBatches) {
+				t.Errorf("batches sent to first = %v, want %v", first.batches, tt.wantBatches)
+			}
+			if !reflect.DeepEqual(second.batches, tt.wantResent) {
+				t.Errorf("batches sent to second = %v, want %v", second.batches, tt.wantResent)
+			}
+
+			for i, res := range results {
+				if res.Err != nil {
+					t.Fatalf("element %d: %v", i, res.Err)
+				}
+				if string(res.Response.ID) != tt.ids[i] {
+					t.Errorf("element %d: id = %s, want %s", i, res.Response.ID, tt.ids[i])
+				}
+				if want := fmt.Sprintf(`[%d]`, i); string(res.Response.Result) != want {
+					t.Errorf("element %d: result = %s, want %s", i, res.Response.Result, want)
+				}
+				wantProvider := first.name
+				if tt.omit[fmt.Sprint(i)] {
+					wantProvider = second.name
+				}
+				if res.Provider != wantProvider {
+					t.Errorf("element %d: provider = %s, want %s", i, res.Provider, wantProvider)
+				}
+			}
+		})
+	}
+}
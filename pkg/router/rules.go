@@ -0,0 +1,145 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+// routeHeader tells the client which routing rule handled its request
+const routeHeader = "X-Heimdall-Route"
+
+// RuleEngine matches requests against the configured routing rules
+type RuleEngine struct {
+	rules atomic.Pointer[ruleSet] // swapped by Reload
+}
+
+// ruleSet is the matching rules in order and the fallback route
+type ruleSet struct {
+	rules    []config.RoutingRule
+	fallback *pool.Route
+}
+
+// NewRuleEngine creates a rule engine from the routing config
+func NewRuleEngine(rules []config.RoutingRule) *RuleEngine {
+	e := &RuleEngine{}
+	e.Reload(rules)
+	return e
+}
+
+// Reload swaps in new routing rules
+func (e *RuleEngine) Reload(rules []config.RoutingRule) {
+	set := &ruleSet{}
+	for _, rule := range rules {
+		if rule.Fallback {
+			set.fallback = ruleRoute(rule)
+			continue
+		}
+		set.rules = append(set.rules, rule)
+	}
+	e.rules.Store(set)
+}
+
+// Match returns the route of the first matching rule, the fallback route, or nil
+// when no rule applies and the whole pool should be used
+func (e *RuleEngine) Match(req *provider.RPCRequest, headers http.Header) *pool.Route {
+	if e == nil {
+		return nil
+	}
+
+	set := e.rules.Load()
+	for _, rule := range set.rules {
+		if matchMethod(rule.Match.Methods, req.Method) &&
+			matchHeaders(rule.Match.Headers, headers) &&
+			matchParams(rule.Match.Params, req.Params) {
+			return ruleRoute(rule)
+		}
+	}
+
+	return set.fallback
+}
+
+func ruleRoute(rule config.RoutingRule) *pool.Route {
+	return &pool.Route{
+		Name:      rule.Name,
+		Providers: rule.Providers,
+		Strategy:  rule.Strategy,
+	}
+}
+
+// matchMethod matches exact method names and "prefix*" patterns; no patterns matches everything
+func matchMethod(patterns []string, method string) bool {
+	return len(patterns) == 0 || config.MatchMethod(patterns, method)
+}
+
+// matchHeaders requires every configured header to be present with the given value ("*" = any)
+func matchHeaders(want map[string]string, headers http.Header) bool {
+	for name, value := range want {
+		got := headers.Get(name)
+		if got == "" || (value != "*" && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchParams checks the param count and the keys and values of the config object
+func matchParams(want config.ParamMatch, params json.RawMessage) bool {
+	if want.MinCount == 0 && len(want.HasKeys) == 0 && len(want.Values) == 0 {
+		return true
+	}
+
+	count, cfg := paramShape(params)
+	if count < want.MinCount {
+		return false
+	}
+
+	for _, key := range want.HasKeys {
+		if _, ok := cfg[key]; !ok {
+			return false
+		}
+	}
+
+	for key, value := range want.Values {
+		raw, ok := cfg[key]
+		if !ok {
+			return false
+		}
+		var s string
+		if json.Unmarshal(raw, &s) != nil {
+			s = string(raw)
+		}
+		if s != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// paramShape returns the number of params and the config object: the by-name params
+// object itself, or the trailing object of positional params
+func paramShape(params json.RawMessage) (int, map[string]json.RawMessage) {
+	trimmed := bytes.TrimSpace(params)
+	if len(trimmed) == 0 {
+		return 0, nil
+	}
+
+	var cfg map[string]json.RawMessage
+	if trimmed[0] == '{' {
+		json.Unmarshal(trimmed, &cfg)
+		return len(cfg), cfg
+	}
+
+	var positional []json.RawMessage
+	if json.Unmarshal(trimmed, &positional) != nil || len(positional) == 0 {
+		return 0, nil
+	}
+	json.Unmarshal(positional[len(positional)-1], &cfg)
+	return len(positional), cfg
+}
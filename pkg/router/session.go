@@ -0,0 +1,228 @@
+package router
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/config"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+)
+
+const (
+	sessionKeyPrefix = "session:slot:"
+
+	// errMinContextSlotNotReached is the error Solana nodes return for a minContextSlot they have not reached
+	errMinContextSlotNotReached = -32016
+)
+
+// minContextSlotParam maps methods that accept minContextSlot to the position of their
+// config object in positional params
+var minContextSlotParam = map[string]int{
+	"getAccountInfo":             1,
+	"getBalance":                 1,
+	"getBlockHeight":             0,
+	"getEpochInfo":               0,
+	"getFeeForMessage":           1,
+	"getLatestBlockhash":         0,
+	"getMultipleAccounts":        1,
+	"getProgramAccounts":         1,
+	"getSignaturesForAddress":    1,
+	"getSlot":                    0,
+	"getSlotLeader":              0,
+	"getStakeActivation":         1,
+	"getTokenAccountBalance":     1,
+	"getTokenAccountsByDelegate": 2,
+	"getTokenAccountsByOwner":    2,
+	"getTransactionCount":        0,
+	"isBlockhashValid":           1,
+	"sendTransaction":            1,
+	"simulateTransaction":        1,
+}
+
+// maxSlotScript raises the stored session slot and refreshes its TTL atomically
+var maxSlotScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+else
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// SessionTracker gives client sessions read-your-writes consistency. It records the
+// highest context slot each session has seen in Redis, so later reads are never answered
+// from an older slot by a lagging provider, whichever replica serves them.
+type SessionTracker struct {
+	redis  *redis.Client
+	config atomic.Pointer[config.ConsistencyConfig] // swapped by Reload
+}
+
+// NewSessionTracker creates a new session tracker
+func NewSessionTracker(redisClient *redis.Client, cfg config.ConsistencyConfig) *SessionTracker {
+	t := &SessionTracker{redis: redisClient}
+	t.config.Store(&cfg)
+	return t
+}
+
+// Reload swaps in new consistency settings. Slots already recorded for sessions are kept.
+func (t *SessionTracker) Reload(cfg config.ConsistencyConfig) {
+	t.config.Store(&cfg)
+}
+
+// SessionID returns the session a request belongs to, or "" if it has none
+func (t *SessionTracker) SessionID(headers http.Header) string {
+	if t == nil {
+		return ""
+	}
+	cfg := t.config.Load()
+	if !cfg.Enabled {
+		return ""
+	}
+	for _, name := range cfg.SessionHeaders {
+		if value := headers.Get(name); value != "" {
+			// Session ids may be API keys, so only a hash is stored
+			return fmt.Sprintf("%x", sha256.Sum256([]byte(name+":"+value)))
+		}
+	}
+	return ""
+}
+
+// MinSlot returns the highest context slot the session has seen (0 if none)
+func (t *SessionTracker) MinSlot(ctx context.Context, session string) uint64 {
+	if session == "" {
+		return 0
+	}
+	val, err := t.redis.Get(ctx, sessionKeyPrefix+session).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("[SESSION] Failed to read session slot: %v", err)
+		}
+		return 0
+	}
+	slot, _ := strconv.ParseUint(val, 10, 64)
+	return slot
+}
+
+// Observe records the context slot of a response the session has received
+func (t *SessionTracker) Observe(ctx context.Context, session string, resp *provider.RPCResponse) {
+	if session == "" || resp == nil || resp.ContextSlot == 0 {
+		return
+	}
+	err := maxSlotScript.Run(ctx, t.redis, []string{sessionKeyPrefix + session},
+		resp.ContextSlot, t.config.Load().SessionTTL.Milliseconds()).Err()
+	if err != nil {
+		log.Printf("[SESSION] Failed to record session slot: %v", err)
+	}
+}
+
+// WithSession returns a context carrying the session's minimum context slot for RetryHandler
+func (t *SessionTracker) WithSession(ctx context.Context, minSlot uint64) context.Context {
+	if minSlot == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionContextKey{}, sessionSlot{
+		minSlot: minSlot,
+		inject:  t.config.Load().Mode == "inject",
+	})
+}
+
+type sessionContextKey struct{}
+
+// sessionSlot is the consistency requirement of a session request
+type sessionSlot struct {
+	minSlot uint64
+	inject  bool
+}
+
+// sessionFromContext returns the session requirement carried by ctx, if any
+func sessionFromContext(ctx context.Context) (sessionSlot, bool) {
+	s, ok := ctx.Value(sessionContextKey{}).(sessionSlot)
+	return s, ok
+}
+
+// prepare returns the request to send upstream: in inject mode it carries minContextSlot
+func (s sessionSlot) prepare(req *provider.RPCRequest) *provider.RPCRequest {
+	if !s.inject {
+		return req
+	}
+	params, ok := withMinContextSlot(req.Method, req.Params, s.minSlot)
+	if !ok {
+		return req
+	}
+	upstream := *req
+	upstream.Params = params
+	return &upstream
+}
+
+// stale reports whether a response is older than the session, either by its context
+// slot or because the provider refused the injected minContextSlot
+func (s sessionSlot) stale(resp *provider.RPCResponse) bool {
+	if resp.Error != nil {
+		return resp.Error.Code == errMinContextSlotNotReached
+	}
+	return resp.ContextSlot != 0 && resp.ContextSlot < s.minSlot
+}
+
+// withMinContextSlot sets minContextSlot in the config object of positional params. A config
+// object is added when the request has none; requests that already set one are left unchanged.
+func withMinContextSlot(method string, params json.RawMessage, slot uint64) (json.RawMessage, bool) {
+	pos, ok := minContextSlotParam[method]
+	if !ok {
+		return nil, false
+	}
+
+	// Solana has no by-name form of the config object, so by-name params are left as sent
+	if isObject(params) {
+		return nil, false
+	}
+
+	_, cfg := paramShape(params)
+	if _, set := cfg["minContextSlot"]; set {
+		return nil, false
+	}
+
+	var positional []json.RawMessage
+	if len(params) > 0 && json.Unmarshal(params, &positional) != nil {
+		return nil, false
+	}
+
+	switch {
+	case len(positional) == pos:
+		cfg = make(map[string]json.RawMessage)
+		positional = append(positional, nil)
+	case len(positional) == pos+1 && cfg != nil:
+	default:
+		// Missing leading params or an unexpected shape: let the provider report it
+		return nil, false
+	}
+
+	cfg["minContextSlot"] = json.RawMessage(strconv.FormatUint(slot, 10))
+	encoded, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, false
+	}
+	positional[pos] = encoded
+
+	out, err := json.Marshal(positional)
+	return out, err == nil
+}
+
+// isObject reports whether raw JSON is an object
+func isObject(raw json.RawMessage) bool {
+	for _, c := range raw {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c == '{'
+	}
+	return false
+}
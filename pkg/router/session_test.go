@@ -0,0 +1,47 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWithMinContextSlot(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		params string
+		want   string // "" when the params are left unchanged
+	}{
+		{"config added after leading params", "getBalance", `["addr"]`, `["addr",{"minContextSlot":500}]`},
+		{"config added without params", "getSlot", ``, `[{"minContextSlot":500}]`},
+		{"config added to empty params", "getSlot", `[]`, `[{"minContextSlot":500}]`},
+		{"existing config extended", "getBalance", `["addr",{"commitment":"confirmed"}]`, `["addr",{"commitment":"confirmed","minContextSlot":500}]`},
+		{"config at third position", "getTokenAccountsByOwner", `["owner",{"mint":"m"},{"encoding":"jsonParsed"}]`, `["owner",{"mint":"m"},{"encoding":"jsonParsed","minContextSlot":500}]`},
+		{"config added at third position", "getTokenAccountsByOwner", `["owner",{"mint":"m"}]`, `["owner",{"mint":"m"},{"minContextSlot":500}]`},
+		{"already set", "getBalance", `["addr",{"minContextSlot":100}]`, ``},
+		{"unsupported method", "getBlock", `[100]`, ``},
+		{"by-name params", "getSlot", `{"commitment":"confirmed"}`, ``},
+		{"missing leading params", "getBalance", `[]`, ``},
+		{"too many params", "getBalance", `["addr",{},"extra"]`, ``},
+		{"non-object in config position", "getBalance", `["addr","confirmed"]`, ``},
+		{"invalid JSON", "getBalance", `["addr"`, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := withMinContextSlot(tt.method, json.RawMessage(tt.params), 500)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("withMinContextSlot(%s, %s) = %s, want unchanged", tt.method, tt.params, got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("withMinContextSlot(%s, %s) left params unchanged, want %s", tt.method, tt.params, tt.want)
+			}
+			if string(got) != tt.want {
+				t.Errorf("withMinContextSlot(%s, %s) = %s, want %s", tt.method, tt.params, got, tt.want)
+			}
+		})
+	}
+}
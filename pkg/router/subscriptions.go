@@ -0,0 +1,681 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/health"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/metrics"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/pool"
+	"github.com/kanurkarprateek/rpc-load-balancer/pkg/provider"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	upstreamCallTimeout       = 10 * time.Second
+	subscriptionWatchInterval = 2 * time.Second
+	resubscribeBackoff        = 2 * time.Second
+	clientWriteTimeout        = 5 * time.Second
+)
+
+// errSubscriptionAbandoned is returned by attach when every subscriber left while the
+// upstream subscription was being created
+var errSubscriptionAbandoned = errors.New("subscription has no subscribers left")
+
+// wsMessage is a generic JSON-RPC message exchanged over a WebSocket
+type wsMessage struct {
+	JSONRPC string             `json:"jsonrpc"`
+	ID      json.RawMessage    `json:"id,omitempty"`
+	Method  string             `json:"method,omitempty"`
+	Params  json.RawMessage    `json:"params,omitempty"`
+	Result  json.RawMessage    `json:"result,omitempty"`
+	Error   *provider.RPCError `json:"error,omitempty"`
+}
+
+// wsNotificationParams is the params object of a subscription notification
+type wsNotificationParams struct {
+	Result       json.RawMessage `json:"result"`
+	Subscription uint64          `json:"subscription"`
+}
+
+// wsClient is a downstream WebSocket connection
+type wsClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	subs    map[uint64]*upstreamSub // client subscription id -> shared subscription
+}
+
+// clientSub is one downstream subscriber of a shared upstream subscription
+type clientSub struct {
+	id     uint64
+	client *wsClient
+	active bool // set once the client has received its subscription id
+}
+
+// upstreamSub is a single upstream subscription shared by identical downstream subscriptions
+type upstreamSub struct {
+	key         string
+	method      string
+	params      json.RawMessage
+	subscribers map[uint64]*clientSub
+	upstream    *upstreamConn
+	upstreamID  uint64
+	ready       chan struct{}
+	err         error
+}
+
+// upstreamConn is a WebSocket connection to a provider that multiplexes subscriptions
+type upstreamConn struct {
+	provider  provider.Provider
+	conn      *websocket.Conn
+	writeMu   sync.Mutex
+	nextID    uint64
+	pending   map[uint64]chan *wsMessage // request id -> response
+	subs      map[uint64]*upstreamSub    // upstream subscription id -> subscription
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// SubscriptionManager proxies WebSocket subscriptions to healthy providers and moves them
+// to another provider when the upstream connection fails or its circuit breaker opens.
+// Client subscription ids are issued by the manager, so they survive a failover.
+type SubscriptionManager struct {
+	pool         *pool.ProviderPool
+	retryHandler *RetryHandler
+	rules        *RuleEngine
+	auth         *Authenticator
+
+	mu           sync.Mutex // guards everything below and the maps of subscriptions and connections
+	upstreams    map[string]*upstreamConn
+	subs         map[string]*upstreamSub
+	nextClientID uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewSubscriptionManager creates a new subscription manager
+func NewSubscriptionManager(providerPool *pool.ProviderPool, retryHandler *RetryHandler, rules *RuleEngine, auth *Authenticator) *SubscriptionManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SubscriptionManager{
+		pool:         providerPool,
+		retryHandler: retryHandler,
+		rules:        rules,
+		auth:         auth,
+		upstreams:    make(map[string]*upstreamConn),
+		subs:         make(map[string]*upstreamSub),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Start begins watching upstream providers for breaker and health changes
+func (m *SubscriptionManager) Start() {
+	ticker := time.NewTicker(subscriptionWatchInterval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				m.checkUpstreams()
+			case <-m.ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop closes all upstream connections
+func (m *SubscriptionManager) Stop() {
+	m.cancel()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, up := range m.upstreams {
+		up.conn.Close()
+	}
+}
+
+// HandleWebSocket upgrades the request and serves JSON-RPC over the WebSocket
+func (m *SubscriptionManager) HandleWebSocket(c *gin.Context) {
+	server := websocket.Server{
+		// Accept clients that do not send an Origin header (non-browser SDKs)
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   m.serveClient,
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+func (m *SubscriptionManager) serveClient(conn *websocket.Conn) {
+	client := &wsClient{
+		conn: conn,
+		subs: make(map[uint64]*upstreamSub),
+	}
+	defer m.disconnect(client)
+
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			client.send(&wsMessage{JSONRPC: "2.0", Error: &provider.RPCError{Code: -32700, Message: "Parse error: invalid JSON"}})
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(msg.Method, "Unsubscribe"):
+			m.unsubscribe(client, &msg)
+		case strings.HasSuffix(msg.Method, "Subscribe"):
+			m.subscribe(client, &msg)
+		default:
+			m.forwardRPC(client, data)
+		}
+	}
+}
+
+// send writes a message to the client
+func (c *wsClient) send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	return websocket.Message.Send(c.conn, string(data))
+}
+
+// forwardRPC answers a plain (non-subscription) request through the retry handler,
+// validated, rate limited and accounted like a request to the HTTP endpoint
+func (m *SubscriptionManager) forwardRPC(client *wsClient, data []byte) {
+	req, errResp := parseRequest(data)
+	if errResp != nil {
+		log.Printf("[WS] Invalid JSON-RPC request: %s", errResp.Error.Message)
+		client.send(errResp)
+		return
+	}
+
+	// The client and its limits were attached to the upgrade request by the authenticator
+	upgradeCtx := client.conn.Request().Context()
+	if rpcErr := m.auth.Allow(upgradeCtx); rpcErr != nil {
+		if !req.IsNotification() {
+			client.send(newErrorResponse(req.ID, rpcErr.Code, rpcErr.Message))
+		}
+		return
+	}
+	ctx := withClient(m.ctx, clientFromContext(upgradeCtx))
+	if route := m.rules.Match(req, client.conn.Request().Header); route != nil {
+		ctx = pool.WithRoute(ctx, route)
+		metrics.RoutingRuleMatches.WithLabelValues(route.Name, req.Method).Inc()
+	}
+
+	// Notifications are executed but never answered; providers only answer requests
+	// with an id, so use a local one upstream
+	upstreamReq := *req
+	if req.IsNotification() {
+		upstreamReq.ID = json.RawMessage("0")
+	}
+
+	resp, providerName, err := m.retryHandler.ExecuteWithRetry(ctx, &upstreamReq)
+	if err != nil {
+		log.Printf("[WS] Failed to forward request: %v", err)
+		countRequest(ctx, providerName, req.Method, "error")
+		if !req.IsNotification() {
+			client.send(newErrorResponse(req.ID, -32603, fmt.Sprintf("Internal error: %v", err)))
+		}
+		return
+	}
+
+	countRequest(ctx, providerName, req.Method, "success")
+	recordCost(ctx, m.pool, providerName, req.Method)
+	if req.IsNotification() {
+		return
+	}
+
+	// Always answer with the client's id, whatever the provider echoed
+	resp.ID = req.ID
+	client.send(resp)
+}
+
+// subscribe attaches the client to a shared upstream subscription, creating it if needed
+func (m *SubscriptionManager) subscribe(client *wsClient, msg *wsMessage) {
+	key := subscriptionKey(msg.Method, msg.Params)
+
+	m.mu.Lock()
+	sub, exists := m.subs[key]
+	if !exists {
+		sub = &upstreamSub{
+			key:         key,
+			method:      msg.Method,
+			params:      msg.Params,
+			subscribers: make(map[uint64]*clientSub),
+			ready:       make(chan struct{}),
+		}
+		m.subs[key] = sub
+	}
+	m.nextClientID++
+	cs := &clientSub{id: m.nextClientID, client: client}
+	sub.subscribers[cs.id] = cs
+	client.subs[cs.id] = sub
+	m.mu.Unlock()
+
+	if !exists {
+		_, sub.err = m.attach(sub, nil)
+		if sub.err != nil {
+			m.mu.Lock()
+			if m.subs[key] == sub {
+				delete(m.subs, key)
+			}
+			m.mu.Unlock()
+		}
+		close(sub.ready)
+	}
+	<-sub.ready
+
+	if sub.err != nil {
+		m.release(client, cs.id)
+		log.Printf("[WS] Subscribe %s failed: %v", msg.Method, sub.err)
+		client.send(&wsMessage{JSONRPC: "2.0", ID: msg.ID, Error: &provider.RPCError{Code: -32603, Message: fmt.Sprintf("Internal error: %v", sub.err)}})
+		return
+	}
+
+	result, _ := json.Marshal(cs.id)
+	client.send(&wsMessage{JSONRPC: "2.0", ID: msg.ID, Result: result})
+
+	m.mu.Lock()
+	cs.active = true
+	m.mu.Unlock()
+}
+
+// unsubscribe detaches the client from a subscription by its client-facing id
+func (m *SubscriptionManager) unsubscribe(client *wsClient, msg *wsMessage) {
+	var params []uint64
+	if err := json.Unmarshal(msg.Params, &params); err != nil || len(params) != 1 {
+		client.send(&wsMessage{JSONRPC: "2.0", ID: msg.ID, Error: &provider.RPCError{Code: -32602, Message: "Invalid params: expected [subscription id]"}})
+		return
+	}
+
+	result, _ := json.Marshal(m.release(client, params[0]))
+	client.send(&wsMessage{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+// disconnect releases every subscription held by a closed client
+func (m *SubscriptionManager) disconnect(client *wsClient) {
+	m.mu.Lock()
+	ids := make([]uint64, 0, len(client.subs))
+	for id := range client.subs {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		m.release(client, id)
+	}
+}
+
+// release removes one client subscription and drops the upstream subscription
+// once it has no subscribers left. It reports whether the id was known.
+func (m *SubscriptionManager) release(client *wsClient, id uint64) bool {
+	m.mu.Lock()
+	sub, ok := client.subs[id]
+	if !ok {
+		m.mu.Unlock()
+		return false
+	}
+	delete(client.subs, id)
+	delete(sub.subscribers, id)
+
+	if len(sub.subscribers) > 0 {
+		m.mu.Unlock()
+		return true
+	}
+
+	if m.subs[sub.key] == sub {
+		delete(m.subs, sub.key)
+	}
+	up, upID := sub.upstream, sub.upstreamID
+	if up != nil {
+		delete(up.subs, upID)
+		sub.upstream = nil
+		metrics.ActiveSubscriptions.WithLabelValues(up.provider.Name()).Dec()
+	}
+	m.mu.Unlock()
+
+	if up != nil {
+		params, _ := json.Marshal([]uint64{upID})
+		go m.call(up, unsubscribeMethod(sub.method), params)
+	}
+	return true
+}
+
+// attach subscribes upstream on the first available provider not in exclude and returns
+// the provider's name. If the subscription was abandoned meanwhile, the new upstream
+// subscription is dropped again and errSubscriptionAbandoned is returned.
+func (m *SubscriptionManager) attach(sub *upstreamSub, exclude map[string]bool) (string, error) {
+	tried := make(map[string]bool)
+	for name := range exclude {
+		tried[name] = true
+	}
+
+	for {
+		prov, err := m.pool.NextWithExclude(m.ctx, tried)
+		if err != nil {
+			return "", err
+		}
+		tried[prov.Name()] = true
+
+		if !m.retryHandler.IsAvailable(prov.Name()) {
+			continue
+		}
+
+		up, err := m.upstreamFor(prov)
+		if err != nil {
+			log.Printf("[WS] Failed to connect to provider %s: %v", prov.Name(), err)
+			continue
+		}
+
+		result, err := m.call(up, sub.method, sub.params)
+		if err != nil {
+			log.Printf("[WS] Provider %s rejected %s: %v", prov.Name(), sub.method, err)
+			continue
+		}
+
+		var upID uint64
+		if err := json.Unmarshal(result, &upID); err != nil {
+			log.Printf("[WS] Provider %s returned invalid subscription id: %s", prov.Name(), string(result))
+			continue
+		}
+
+		m.mu.Lock()
+		if len(sub.subscribers) == 0 || m.subs[sub.key] != sub {
+			m.mu.Unlock()
+			params, _ := json.Marshal([]uint64{upID})
+			go m.call(up, unsubscribeMethod(sub.method), params)
+			return "", errSubscriptionAbandoned
+		}
+		sub.upstream = up
+		sub.upstreamID = upID
+		up.subs[upID] = sub
+		m.mu.Unlock()
+
+		metrics.ActiveSubscriptions.WithLabelValues(prov.Name()).Inc()
+		return prov.Name(), nil
+	}
+}
+
+// upstreamFor returns the open connection to a provider, dialing one if needed
+func (m *SubscriptionManager) upstreamFor(prov provider.Provider) (*upstreamConn, error) {
+	m.mu.Lock()
+	up, ok := m.upstreams[prov.Name()]
+	m.mu.Unlock()
+	if ok {
+		return up, nil
+	}
+
+	cfg, err := websocket.NewConfig(prov.WSURL(), prov.URL())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, upstreamCallTimeout)
+	defer cancel()
+	conn, err := cfg.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if existing, ok := m.upstreams[prov.Name()]; ok {
+		// Another subscriber connected first
+		m.mu.Unlock()
+		conn.Close()
+		return existing, nil
+	}
+	up = &upstreamConn{
+		provider: prov,
+		conn:     conn,
+		pending:  make(map[uint64]chan *wsMessage),
+		subs:     make(map[uint64]*upstreamSub),
+		closed:   make(chan struct{}),
+	}
+	m.upstreams[prov.Name()] = up
+	m.mu.Unlock()
+
+	log.Printf("[WS] Connected upstream WebSocket to provider %s", prov.Name())
+	go m.readUpstream(up)
+	return up, nil
+}
+
+// call sends a request on an upstream connection and waits for its result
+func (m *SubscriptionManager) call(up *upstreamConn, method string, params json.RawMessage) (json.RawMessage, error) {
+	m.mu.Lock()
+	up.nextID++
+	id := up.nextID
+	ch := make(chan *wsMessage, 1)
+	up.pending[id] = ch
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(up.pending, id)
+		m.mu.Unlock()
+	}()
+
+	reqID, _ := json.Marshal(id)
+	data, err := json.Marshal(&wsMessage{JSONRPC: "2.0", ID: reqID, Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+
+	up.writeMu.Lock()
+	up.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	err = websocket.Message.Send(up.conn, string(data))
+	up.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, fmt.Errorf("%s", resp.Error.Message)
+		}
+		return resp.Result, nil
+	case <-up.closed:
+		return nil, fmt.Errorf("upstream connection closed")
+	case <-time.After(upstreamCallTimeout):
+		return nil, fmt.Errorf("timed out waiting for upstream response")
+	}
+}
+
+// readUpstream routes responses and notifications from a provider until the connection fails
+func (m *SubscriptionManager) readUpstream(up *upstreamConn) {
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(up.conn, &data); err != nil {
+			m.upstreamFailed(up, err)
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		if msg.Method == "" && len(msg.ID) > 0 {
+			var id uint64
+			if json.Unmarshal(msg.ID, &id) != nil {
+				continue
+			}
+			m.mu.Lock()
+			ch := up.pending[id]
+			m.mu.Unlock()
+			if ch != nil {
+				ch <- &msg
+			}
+			continue
+		}
+
+		if strings.HasSuffix(msg.Method, "Notification") {
+			m.dispatch(up, &msg)
+		}
+	}
+}
+
+// dispatch fans a notification out to every subscriber with its own subscription id
+func (m *SubscriptionManager) dispatch(up *upstreamConn, msg *wsMessage) {
+	var params wsNotificationParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	sub, ok := up.subs[params.Subscription]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	var targets []*clientSub
+	for _, cs := range sub.subscribers {
+		if cs.active {
+			targets = append(targets, cs)
+		}
+	}
+
+	// Signature subscriptions end after their first notification
+	oneShot := msg.Method == "signatureNotification"
+	if oneShot {
+		delete(up.subs, params.Subscription)
+		if m.subs[sub.key] == sub {
+			delete(m.subs, sub.key)
+		}
+		for id, cs := range sub.subscribers {
+			delete(cs.client.subs, id)
+		}
+		sub.subscribers = make(map[uint64]*clientSub)
+		sub.upstream = nil
+		metrics.ActiveSubscriptions.WithLabelValues(up.provider.Name()).Dec()
+	}
+	m.mu.Unlock()
+
+	for _, cs := range targets {
+		out, _ := json.Marshal(&wsNotificationParams{Result: params.Result, Subscription: cs.id})
+		cs.client.send(&wsMessage{JSONRPC: "2.0", Method: msg.Method, Params: out})
+	}
+}
+
+// upstreamFailed drops a broken connection and moves its subscriptions to other providers
+func (m *SubscriptionManager) upstreamFailed(up *upstreamConn, err error) {
+	up.closeOnce.Do(func() { close(up.closed) })
+	up.conn.Close()
+
+	m.mu.Lock()
+	if m.upstreams[up.provider.Name()] == up {
+		delete(m.upstreams, up.provider.Name())
+	}
+	moved := make([]*upstreamSub, 0, len(up.subs))
+	for _, sub := range up.subs {
+		sub.upstream = nil
+		moved = append(moved, sub)
+	}
+	up.subs = make(map[uint64]*upstreamSub)
+	m.mu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return
+	}
+
+	log.Printf("[WS] Upstream connection to %s lost (%v), moving %d subscriptions", up.provider.Name(), err, len(moved))
+	metrics.ActiveSubscriptions.WithLabelValues(up.provider.Name()).Sub(float64(len(moved)))
+	for _, sub := range moved {
+		metrics.SubscriptionFailovers.WithLabelValues(up.provider.Name()).Inc()
+		go m.resubscribe(sub, up.provider.Name())
+	}
+}
+
+// resubscribe re-attaches a subscription until it succeeds or has no subscribers left
+func (m *SubscriptionManager) resubscribe(sub *upstreamSub, failed string) {
+	exclude := map[string]bool{failed: true}
+	for {
+		m.mu.Lock()
+		abandoned := len(sub.subscribers) == 0 || m.subs[sub.key] != sub
+		m.mu.Unlock()
+		if abandoned || m.ctx.Err() != nil {
+			return
+		}
+
+		target, err := m.attach(sub, exclude)
+		if err == nil {
+			log.Printf("[WS] Moved %s subscription from %s to %s", sub.method, failed, target)
+			return
+		}
+		if errors.Is(err, errSubscriptionAbandoned) {
+			return
+		}
+
+		// After a full pass, allow the failed provider again in case it recovered
+		exclude = nil
+
+		select {
+		case <-time.After(resubscribeBackoff):
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkUpstreams closes connections to providers whose breaker opened, that became unhealthy
+// or that a config reload replaced, which moves their subscriptions through upstreamFailed
+func (m *SubscriptionManager) checkUpstreams() {
+	m.mu.Lock()
+	ups := make([]*upstreamConn, 0, len(m.upstreams))
+	for _, up := range m.upstreams {
+		ups = append(ups, up)
+	}
+	m.mu.Unlock()
+
+	for _, up := range ups {
+		name := up.provider.Name()
+		if !m.pool.Contains(up.provider) {
+			log.Printf("[WS] Provider %s was removed or reconfigured, failing over its subscriptions", name)
+			up.conn.Close()
+			continue
+		}
+		if !m.retryHandler.IsAvailable(name) {
+			log.Printf("[WS] Circuit breaker for %s is open, failing over its subscriptions", name)
+			up.conn.Close()
+			continue
+		}
+		status, err := health.GetProviderStatus(m.ctx, m.pool.GetRedis(), name)
+		if err == nil && status != nil && !status.Healthy {
+			log.Printf("[WS] Provider %s is unhealthy, failing over its subscriptions", name)
+			up.conn.Close()
+		}
+	}
+}
+
+// subscriptionKey identifies identical subscriptions that can share one upstream
+func subscriptionKey(method string, params json.RawMessage) string {
+	var v interface{}
+	if err := json.Unmarshal(params, &v); err == nil {
+		// Re-marshal to normalize whitespace and object key order
+		if normalized, err := json.Marshal(v); err == nil {
+			params = normalized
+		}
+	}
+	return method + ":" + string(params)
+}
+
+// unsubscribeMethod maps e.g. accountSubscribe to accountUnsubscribe
+func unsubscribeMethod(subscribeMethod string) string {
+	return strings.TrimSuffix(subscribeMethod, "Subscribe") + "Unsubscribe"
+}
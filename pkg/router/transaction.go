@@ -0,0 +1,118 @@
+package router
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// transactionSignature returns the first signature of the signed transaction in
+// sendTransaction params, which is the transaction id Solana returns on success
+func transactionSignature(params json.RawMessage) (string, error) {
+	var positional []json.RawMessage
+	if err := json.Unmarshal(params, &positional); err != nil || len(positional) == 0 {
+		return "", fmt.Errorf("expected positional params with a transaction")
+	}
+
+	var encoded string
+	if err := json.Unmarshal(positional[0], &encoded); err != nil {
+		return "", fmt.Errorf("transaction must be a string")
+	}
+
+	// Solana's default wire encoding for sendTransaction is base58
+	encoding := "base58"
+	if len(positional) > 1 {
+		var cfg struct {
+			Encoding string `json:"encoding"`
+		}
+		if json.Unmarshal(positional[1], &cfg) == nil && cfg.Encoding != "" {
+			encoding = cfg.Encoding
+		}
+	}
+
+	var wire []byte
+	var err error
+	switch encoding {
+	case "base64":
+		wire, err = base64.StdEncoding.DecodeString(encoded)
+	case "base58":
+		wire, err = base58Decode(encoded)
+	default:
+		return "", fmt.Errorf("unsupported encoding %s", encoding)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to decode transaction: %w", err)
+	}
+
+	// Wire format: compact-u16 signature count followed by 64-byte signatures
+	count, n := decodeShortVec(wire)
+	if n == 0 || count == 0 || len(wire) < n+64 {
+		return "", fmt.Errorf("transaction has no signature")
+	}
+
+	return base58Encode(wire[n : n+64]), nil
+}
+
+// decodeShortVec decodes Solana's compact-u16 length prefix, returning the value
+// and the number of bytes read (0 on malformed input)
+func decodeShortVec(data []byte) (int, int) {
+	value := 0
+	for i := 0; i < 3 && i < len(data); i++ {
+		value |= int(data[i]&0x7f) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for i := 0; i < len(s); i++ {
+		digit := -1
+		for j := 0; j < len(base58Alphabet); j++ {
+			if base58Alphabet[j] == s[i] {
+				digit = j
+				break
+			}
+		}
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	leading := 0
+	for leading < len(s) && s[leading] == base58Alphabet[0] {
+		leading++
+	}
+	return append(make([]byte, leading), n.Bytes()...), nil
+}
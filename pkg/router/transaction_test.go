@@ -0,0 +1,93 @@
+package router
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// wireTransaction builds a signed transaction with the given signatures and a dummy message
+func wireTransaction(sigs ...[]byte) []byte {
+	wire := []byte{byte(len(sigs))}
+	for _, sig := range sigs {
+		wire = append(wire, sig...)
+	}
+	return append(wire, []byte("message")...)
+}
+
+func TestTransactionSignature(t *testing.T) {
+	zero := make([]byte, 64)
+	first := bytes.Repeat([]byte{0xab}, 64)
+	second := bytes.Repeat([]byte{0xcd}, 64)
+
+	signed := wireTransaction(first, second)
+	b58 := base58Encode(signed)
+	b64 := base64.StdEncoding.EncodeToString(signed)
+
+	tests := []struct {
+		name    string
+		params  string
+		want    string
+		wantErr bool
+	}{
+		{"base58 by default", fmt.Sprintf(`[%q]`, b58), base58Encode(first), false},
+		{"explicit base58", fmt.Sprintf(`[%q,{"encoding":"base58"}]`, b58), base58Encode(first), false},
+		{"base64", fmt.Sprintf(`[%q,{"encoding":"base64","skipPreflight":true}]`, b64), base58Encode(first), false},
+		{"config without encoding", fmt.Sprintf(`[%q,{"skipPreflight":true}]`, b58), base58Encode(first), false},
+		{"leading zero bytes", fmt.Sprintf(`[%q]`, base58Encode(wireTransaction(zero))), strings.Repeat("1", 64), false},
+		{"no signatures", fmt.Sprintf(`[%q]`, base58Encode([]byte{0, 1, 2})), "", true},
+		{"truncated signature", fmt.Sprintf(`[%q]`, base58Encode(append([]byte{1}, first[:10]...))), "", true},
+		{"unsupported encoding", fmt.Sprintf(`[%q,{"encoding":"hex"}]`, b58), "", true},
+		{"invalid base58", `["0OIl"]`, "", true},
+		{"invalid base64", `["!!!",{"encoding":"base64"}]`, "", true},
+		{"not a string", `[42]`, "", true},
+		{"no params", `[]`, "", true},
+		{"by-name params", `{"transaction":"x"}`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := transactionSignature(json.RawMessage(tt.params))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("transactionSignature = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transactionSignature: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("transactionSignature = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBase58(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		encoded string
+	}{
+		{[]byte("hello world"), "StV1DL6CwTryKyV"},
+		{[]byte{0, 0, 1}, "112"},
+		{[]byte{0}, "1"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := base58Encode(tt.data); got != tt.encoded {
+			t.Errorf("base58Encode(%x) = %s, want %s", tt.data, got, tt.encoded)
+		}
+		got, err := base58Decode(tt.encoded)
+		if err != nil {
+			t.Fatalf("base58Decode(%s): %v", tt.encoded, err)
+		}
+		if !bytes.Equal(got, tt.data) {
+			t.Errorf("base58Decode(%s) = %x, want %x", tt.encoded, got, tt.data)
+		}
+	}
+}